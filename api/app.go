@@ -6,6 +6,7 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/outout14/sacrebleu-api/api/types"
 	_ "github.com/outout14/sacrebleu-api/docs" //Swagger
 	"github.com/outout14/sacrebleu-dns/utils"
 	"github.com/sirupsen/logrus"
@@ -20,7 +21,46 @@ func (a *Server) Initialize(conf *utils.Conf) {
 	a.Router = mux.NewRouter()
 	a.APIRouter = a.Router.PathPrefix("/api").Subrouter()
 
-	a.APIRouter.Use(JwtVerify(a))
+	if a.Config == nil {
+		a.Config = new(Config)
+	}
+	sessions, err := newSessionSigner(a.Config.Auth)
+	if err != nil {
+		logrus.Fatalf("AUTH : Can't load the signing key : %s", err)
+	}
+	a.sessions = sessions
+	go a.syncRevocations()
+
+	throttle, err := newLoginThrottle(a.Config.Throttle, a.DB)
+	if err != nil {
+		logrus.Fatalf("AUTH : Can't create the login throttle : %s", err)
+	}
+	a.throttle = throttle
+	a.resets = throttle.withPrefix("reset:")
+	a.mailer = newMailer(a.Config.Mail)
+	a.oidc = newOIDCClient(a.Config.OIDC)
+
+	providers, err := newAuthProviders(a.Config)
+	if err != nil {
+		logrus.Fatalf("AUTH : Can't create the login providers : %s", err)
+	}
+	a.providers = providers
+
+	keyring, err := newTSIGKeyring(a.Config.DNSUpdate.Keys, a.DB)
+	if err != nil {
+		logrus.Fatalf("DNSUPDATE : Can't load the TSIG keys : %s", err)
+	}
+	a.keyring = keyring
+	a.updater = newDNSUpdater(a.Config.DNSUpdate, a.DB, keyring)
+	a.transfer = newDNSTransfer(a.Config.XFR, a.DB, keyring)
+	if err := types.Networks(a.Config.XFR.ImportPrimaries).Validate(); err != nil {
+		logrus.Fatalf("XFR : Invalid primaries of the zone imports : %s", err)
+	}
+	if err := types.Networks(a.Config.Proxy.Trusted).Validate(); err != nil {
+		logrus.Fatalf("PROXY : Invalid trusted proxies : %s", err)
+	}
+
+	a.APIRouter.Use(JwtVerify(a), AuditLog(a))
 	a.initializeRoutes()
 }
 
@@ -33,29 +73,94 @@ func (a *Server) initializeRoutes() {
 		httpSwagger.DeepLinking(true),
 	))
 	logrus.Debug("[SERVER] Ping init")
-	a.APIRouter.HandleFunc("/ping", a.getPing).Methods("GET")
+	a.APIRouter.HandleFunc("/ping", a.getPing).Methods("GET").Name("ping")
 
 	//Domain
-	a.APIRouter.HandleFunc("/domains", a.getDomains).Methods("GET")
-	a.APIRouter.HandleFunc("/domain", a.createDomain).Methods("POST")
-	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.getDomain).Methods("GET")
-	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.updateDomain).Methods("PUT")
-	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.deleteDomain).Methods("DELETE")
-	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/records", a.getDomainRecords).Methods("GET")
+	a.APIRouter.HandleFunc("/domains", a.getDomains).Methods("GET").Name("domains.list")
+	a.APIRouter.HandleFunc("/domain", a.createDomain).Methods("POST").Name("domain.create")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.getDomain).Methods("GET").Name("domain.read")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.updateDomain).Methods("PUT").Name("domain.update")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}", a.deleteDomain).Methods("DELETE").Name("domain.delete")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/records", a.getDomainRecords).Methods("GET").Name("domain.records.list")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/records/batch", a.batchDomainRecords).Methods("POST").Name("domain.records.batch")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/zone", a.getDomainZone).Methods("GET").Name("domain.zone.export")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/zone", a.importDomainZone).Methods("POST").Name("domain.zone.import")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/import/axfr", a.importDomainAXFR).Methods("POST").Name("domain.zone.import.axfr")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/history", a.getDomainHistory).Methods("GET").Name("domain.history")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/rollback", a.rollbackDomain).Methods("POST").Name("domain.rollback")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/members", a.getDomainMembers).Methods("GET").Name("domain.members.list")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/members", a.setDomainMember).Methods("POST").Name("domain.members.set")
+	a.APIRouter.HandleFunc("/domain/{id:[0-9]+}/members/{user_id:[0-9]+}", a.deleteDomainMember).Methods("DELETE").Name("domain.members.delete")
+
+	//Teams
+	a.APIRouter.HandleFunc("/teams", a.getTeams).Methods("GET").Name("teams.list")
+	a.APIRouter.HandleFunc("/team", a.createTeam).Methods("POST").Name("team.create")
+	a.APIRouter.HandleFunc("/team/{id:[0-9]+}", a.getTeam).Methods("GET").Name("team.read")
+	a.APIRouter.HandleFunc("/team/{id:[0-9]+}", a.updateTeam).Methods("PUT").Name("team.update")
+	a.APIRouter.HandleFunc("/team/{id:[0-9]+}", a.deleteTeam).Methods("DELETE").Name("team.delete")
+	a.APIRouter.HandleFunc("/team/{id:[0-9]+}/members", a.setTeamMember).Methods("POST").Name("team.members.set")
+	a.APIRouter.HandleFunc("/team/{id:[0-9]+}/members/{user_id:[0-9]+}", a.deleteTeamMember).Methods("DELETE").Name("team.members.delete")
+
+	//Record permission rules
+	a.APIRouter.HandleFunc("/rules", a.getRules).Methods("GET").Name("rules.list")
+	a.APIRouter.HandleFunc("/rule", a.createRule).Methods("POST").Name("rule.create")
+	a.APIRouter.HandleFunc("/rule/{id:[0-9]+}", a.getRule).Methods("GET").Name("rule.read")
+	a.APIRouter.HandleFunc("/rule/{id:[0-9]+}", a.updateRule).Methods("PUT").Name("rule.update")
+	a.APIRouter.HandleFunc("/rule/{id:[0-9]+}", a.deleteRule).Methods("DELETE").Name("rule.delete")
+
+	//TSIG keys
+	a.APIRouter.HandleFunc("/tsigkeys", a.getTSIGKeys).Methods("GET").Name("tsigkeys.list")
+	a.APIRouter.HandleFunc("/tsigkey", a.createTSIGKey).Methods("POST").Name("tsigkey.create")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}", a.getTSIGKey).Methods("GET").Name("tsigkey.read")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}", a.updateTSIGKey).Methods("PUT").Name("tsigkey.update")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}", a.deleteTSIGKey).Methods("DELETE").Name("tsigkey.delete")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}/secret", a.rotateTSIGKey).Methods("POST").Name("tsigkey.rotate")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}/domains", a.getTSIGKeyDomains).Methods("GET").Name("tsigkey.domains.list")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}/domains", a.setTSIGKeyDomain).Methods("POST").Name("tsigkey.domains.set")
+	a.APIRouter.HandleFunc("/tsigkey/{id:[0-9]+}/domains/{domain_id:[0-9]+}", a.deleteTSIGKeyDomain).Methods("DELETE").Name("tsigkey.domains.delete")
 
 	//Records
-	a.APIRouter.HandleFunc("/record", a.createRecord).Methods("POST")
-	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.getRecord).Methods("GET")
-	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.updateRecord).Methods("PUT")
-	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.deleteRecord).Methods("DELETE")
+	a.APIRouter.HandleFunc("/record", a.createRecord).Methods("POST").Name("record.create")
+	a.APIRouter.HandleFunc("/record/types", a.getRecordTypes).Methods("GET").Name("record.types")
+	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.getRecord).Methods("GET").Name("record.read")
+	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.updateRecord).Methods("PUT").Name("record.update")
+	a.APIRouter.HandleFunc("/record/{id:[0-9]+}", a.deleteRecord).Methods("DELETE").Name("record.delete")
 
 	//Users
 	a.Router.HandleFunc("/api/login", a.login).Methods("POST") //Router object is used and not APIRouter to don't require Token auth
-	a.APIRouter.HandleFunc("/user", a.createUser).Methods("POST")
-	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.getUser).Methods("GET")
-	a.APIRouter.HandleFunc("/user/self", a.getUserSelf).Methods("GET")
-	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.updateUser).Methods("PUT")
-	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.deleteUser).Methods("DELETE")
+	a.Router.HandleFunc("/api/refresh", a.refreshSession).Methods("POST")
+	a.Router.HandleFunc("/api/oidc/login", a.oidcLogin).Methods("GET")
+	a.Router.HandleFunc("/api/oidc/callback", a.oidcCallback).Methods("GET")
+	a.Router.HandleFunc("/api/oidc/totp", a.oidcTOTP).Methods("POST")
+	a.Router.HandleFunc("/api/password/forgot", a.forgotPassword).Methods("POST")
+	a.Router.HandleFunc("/api/password/reset", a.resetPassword).Methods("POST")
+	a.Router.HandleFunc("/api/email/verify", a.verifyEmail).Methods("POST")
+	a.Router.HandleFunc("/acme/register", a.acmeOpenRegister).Methods("POST") //acme-dns compatible API, authenticated by its own credentials
+	a.Router.HandleFunc("/acme/update", a.acmeUpdate).Methods("POST")
+	a.Router.HandleFunc("/acme/health", a.acmeHealth).Methods("GET")
+	a.Router.Handle("/nic/update", AuditLog(a)(http.HandlerFunc(a.dynDNSUpdate))).Methods("GET", "POST").Name("dyndns.update") //DynDNS2 protocol, HTTP Basic auth
+	a.APIRouter.HandleFunc("/acme/register", a.acmeRegister).Methods("POST").Name("acme.register")
+	a.APIRouter.HandleFunc("/acme/registrations", a.getACMERegistrations).Methods("GET").Name("acme.registrations.list")
+	a.APIRouter.HandleFunc("/acme/registration/{id:[0-9]+}", a.deleteACMERegistration).Methods("DELETE").Name("acme.registration.delete")
+	a.APIRouter.HandleFunc("/user/self/email/verify", a.sendEmailVerification).Methods("POST").Name("user.email.verify")
+	a.APIRouter.HandleFunc("/logout", a.logout).Methods("POST").Name("session.logout")
+	a.APIRouter.HandleFunc("/user", a.createUser).Methods("POST").Name("user.create")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.getUser).Methods("GET").Name("user.read")
+	a.APIRouter.HandleFunc("/user/self", a.getUserSelf).Methods("GET").Name("user.self")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.updateUser).Methods("PUT").Name("user.update")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}", a.deleteUser).Methods("DELETE").Name("user.delete")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}/lock", a.unlockUser).Methods("DELETE").Name("user.unlock")
+	a.APIRouter.HandleFunc("/user/self/oidc", a.linkOIDC).Methods("POST").Name("user.oidc.link")
+	a.APIRouter.HandleFunc("/user/self/totp", a.enrollTOTP).Methods("POST").Name("user.totp.enroll")
+	a.APIRouter.HandleFunc("/user/self/totp/confirm", a.confirmTOTP).Methods("POST").Name("user.totp.confirm")
+	a.APIRouter.HandleFunc("/user/self/totp/recovery", a.renewRecoveryCodes).Methods("POST").Name("user.totp.recovery")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}/totp", a.disableTOTP).Methods("DELETE").Name("user.totp.disable")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}/tokens", a.getUserTokens).Methods("GET").Name("user.tokens.list")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}/tokens", a.createUserToken).Methods("POST").Name("user.tokens.create")
+	a.APIRouter.HandleFunc("/user/{id:[0-9]+}/tokens/{token_id:[0-9]+}", a.deleteUserToken).Methods("DELETE").Name("user.tokens.delete")
+
+	//Audit
+	a.APIRouter.HandleFunc("/audit", a.getAudit).Methods("GET").Name("audit.read")
 }
 
 //Ping endpoint (to test the API)
@@ -79,5 +184,12 @@ func (a *Server) Run(conf *utils.Conf) {
 
 	// Insert the middleware
 	handler = c.Handler(handler)
+
+	if a.updater != nil {
+		a.updater.listen()
+	}
+	if a.transfer != nil {
+		a.transfer.listen()
+	}
 	logrus.Fatal(http.ListenAndServe(addr, handler))
 }
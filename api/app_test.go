@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/outout14/sacrebleu-dns/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+//newTestServer : API server on an in-memory database, with an admin and its API token
+func newTestServer(t *testing.T) (*Server, string) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("can't open the database : %s", err)
+	}
+	types.SQLMigrate(db)
+
+	conf := &utils.Conf{}
+	conf.DNS.Nameservers = []string{"ns1.example.net.", "ns2.example.net."}
+	a := &Server{DB: db, Conf: conf, Config: new(Config)}
+	a.Initialize(conf)
+
+	admin := types.User{Email: "admin@example.org", Username: "admin", IsAdmin: true}
+	if err := admin.CreateUser(db); err != nil {
+		t.Fatalf("can't create the admin : %s", err)
+	}
+	token := types.Token{UserID: admin.ID, Name: "test", Secret: GenerateToken(), Scopes: admin.DefaultScopes()}
+	if err := token.CreateToken(db); err != nil {
+		t.Fatalf("can't create the token : %s", err)
+	}
+	return a, token.Secret
+}
+
+//newTestUser : create a user (not admin) with its API token
+func newTestUser(t *testing.T, a *Server, name string) (types.User, string) {
+	u := types.User{Email: name + "@example.org", Username: name}
+	if err := u.CreateUser(a.DB); err != nil {
+		t.Fatalf("can't create the user : %s", err)
+	}
+	token := types.Token{UserID: u.ID, Name: "test", Secret: GenerateToken(), Scopes: u.DefaultScopes()}
+	if err := token.CreateToken(a.DB); err != nil {
+		t.Fatalf("can't create the token : %s", err)
+	}
+	return u, token.Secret
+}
+
+//request : send a request to the API with the token, get the status and the body
+func (a *Server) request(token string, method string, path string, body string) (int, string) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-access-token", token)
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+//statusRecorder : ResponseWriter keeping the HTTP code sent by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+//setAuditDomain : tell the audit log which domain the request is about (when it is not in the URL)
+func setAuditDomain(r *http.Request, domainID int) {
+	context.Set(r, "auditDomain", domainID)
+}
+
+//sourceIP : IP address of the client, the X-Forwarded-For and X-Real-IP headers are only read from the trusted proxies
+func (a *Server) sourceIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	proxies := types.Networks(a.Config.Proxy.Trusted)
+	if len(proxies) == 0 || !proxies.Contains(ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		//Read from the right : the client is the first address not added by a trusted proxy
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+			if hop == nil {
+				return ip
+			}
+			ip = hop.String()
+			if !proxies.Contains(ip) {
+				return ip
+			}
+		}
+		return ip
+	}
+	if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); real != nil {
+		return real.String()
+	}
+	return ip
+}
+
+//AuditLog : Log every authenticated request in the audit log
+//Must be used after JwtVerify
+func AuditLog(a *Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			user, ok := context.Get(r, "user").(types.User)
+			if !ok {
+				return
+			}
+
+			entry := types.AuditEntry{
+				UserID:   user.ID,
+				Method:   r.Method,
+				Endpoint: r.URL.Path,
+				Status:   recorder.status,
+				SourceIP: a.sourceIP(r),
+			}
+
+			//The route name is the action (eg : record.delete), the target is the first part of it
+			if route := mux.CurrentRoute(r); route != nil {
+				entry.Action = route.GetName()
+			}
+			entry.TargetType = strings.Split(entry.Action, ".")[0]
+			entry.TargetID, _ = strconv.Atoi(mux.Vars(r)["id"])
+
+			if entry.TargetType == "domain" {
+				entry.DomainID = entry.TargetID
+			}
+			if domainID, ok := context.Get(r, "auditDomain").(int); ok {
+				entry.DomainID = domainID
+			}
+
+			if err := entry.CreateAuditEntry(a.DB); err != nil {
+				logrus.WithFields(logrus.Fields{"action": entry.Action, "user": entry.UserID}).Errorf("AUDIT : Can't write entry : %s", err)
+			}
+		})
+	}
+}
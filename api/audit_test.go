@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSourceIP(t *testing.T) {
+	a := &Server{Config: &Config{Proxy: Proxy{Trusted: []string{"10.0.0.0/8"}}}}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		ip        string
+	}{
+		{"direct", "192.0.2.1:1234", "", "", "192.0.2.1"},
+		{"untrusted proxy", "192.0.2.1:1234", "198.51.100.1", "198.51.100.2", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"proxies chain", "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"spoofed address", "10.0.0.1:1234", "203.0.113.1, 198.51.100.1", "", "198.51.100.1"},
+		{"invalid address", "10.0.0.1:1234", "foo, 10.0.0.2", "", "10.0.0.2"},
+		{"real ip", "10.0.0.1:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"no header", "10.0.0.1:1234", "", "", "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/ping", nil)
+			r.RemoteAddr = test.remote
+			if test.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", test.forwarded)
+			}
+			if test.realIP != "" {
+				r.Header.Set("X-Real-IP", test.realIP)
+			}
+			if ip := a.sourceIP(r); ip != test.ip {
+				t.Errorf("got %s, want %s", ip, test.ip)
+			}
+		})
+	}
+
+	a.Config.Proxy.Trusted = nil
+	r := httptest.NewRequest("GET", "/api/ping", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if ip := a.sourceIP(r); ip != "10.0.0.1" {
+		t.Errorf("headers read without trusted proxies : got %s", ip)
+	}
+}
@@ -1,24 +1,104 @@
 package api
 
 import (
-	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
 	"github.com/outout14/sacrebleu-api/api/types"
 
 	"github.com/sirupsen/logrus"
 )
 
+//recordsActions : actions allowed to the tokens with records:<domain_id> scopes only (in addition to reads)
+var recordsActions = map[string]bool{
+	"record.create":           true,
+	"record.update":           true,
+	"record.delete":           true,
+	"domain.records.batch":    true,
+	"domain.zone.import":      true,
+	"domain.zone.import.axfr": true,
+}
+
+//tokenAllows : check if the token scopes allow the request (the domain is checked later by domainVerify)
+func tokenAllows(token types.Token, r *http.Request) bool {
+	if token.HasScope(types.ScopeWrite) || token.HasScope(types.ScopeAdmin) {
+		return true
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return len(token.Scopes) > 0
+	}
+
+	route := mux.CurrentRoute(r)
+	return route != nil && recordsActions[route.GetName()] && len(token.RecordsDomains()) > 0
+}
+
+//sessionAuth : authenticate a JWT access token (without database request)
+func (a *Server) sessionAuth(w http.ResponseWriter, r *http.Request, header string) (types.User, types.Token, bool) {
+	claims, err := a.sessions.parse(header)
+	switch err {
+	case nil:
+	case errTokenExpired:
+		respondWithError(w, http.StatusForbidden, "Token expired.")
+		return types.User{}, types.Token{}, true
+	case errSessionRevoked:
+		respondWithError(w, http.StatusForbidden, "Session revoked.")
+		return types.User{}, types.Token{}, true
+	default:
+		logrus.WithFields(logrus.Fields{"error": err}).Debug("AUTH : JWT invalid.")
+		respondWithError(w, http.StatusForbidden, "Token invalid.")
+		return types.User{}, types.Token{}, true
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Token invalid.")
+		return types.User{}, types.Token{}, true
+	}
+
+	user := types.User{ID: id, Username: claims.Username, Email: claims.Email, IsAdmin: true} //The admin rights are given by the scopes
+	token := types.Token{UserID: id, Name: "session", Scopes: claims.Scopes}
+	context.Set(r, "session", claims.SessionID)
+	return user, token, false
+}
+
+//apiTokenAuth : authenticate an API token (saved in the database)
+func (a *Server) apiTokenAuth(w http.ResponseWriter, header string) (types.User, types.Token, bool) {
+	token := types.Token{Secret: header}
+	err := token.GetTokenBySecret(a.DB)
+	if err != nil {
+		logrus.Debug("AUTH : Token invalid.")
+		respondWithError(w, http.StatusForbidden, "Token invalid.")
+		return types.User{}, token, true
+	}
+	if token.Expired() {
+		logrus.WithFields(logrus.Fields{"token": token.ID}).Debug("AUTH : Token expired.")
+		respondWithError(w, http.StatusForbidden, "Token expired.")
+		return types.User{}, token, true
+	}
+
+	user := types.User{ID: token.UserID}
+	err = user.GetUser(a.DB)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"token": token.ID}).Debug("AUTH : Token user not found.")
+		respondWithError(w, http.StatusForbidden, "Token invalid.")
+		return user, token, true
+	}
+
+	token.Touch(a.DB)
+	return user, token, false
+}
+
 //JwtVerify : Token verification
+//The header contains either a JWT access token (from login) or an API token.
+//The token scopes are applied to the user passed to the handlers : without the admin scope, the user is not admin
 func JwtVerify(a *Server) func(http.Handler) http.Handler {
 	//Method to pass the *Server struct and get access to the SQL DB
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var header = r.Header.Get("x-access-token")
-
-			json.NewEncoder(w).Encode(r)
 			header = strings.TrimSpace(header)
 
 			if header == "" {
@@ -28,15 +108,29 @@ func JwtVerify(a *Server) func(http.Handler) http.Handler {
 			}
 
 			var user types.User
-			result := a.DB.Where("token = ?", header).First(&user)
-			if result.Error != nil {
-				logrus.WithFields(logrus.Fields{"x-access-token": header}).Debug("AUTH : Token invalid.")
-				respondWithError(w, http.StatusForbidden, "Token invalid.")
+			var token types.Token
+			var dbg bool
+			if strings.Count(header, ".") == 2 { //JWT : header.payload.signature
+				user, token, dbg = a.sessionAuth(w, r, header)
+			} else {
+				user, token, dbg = a.apiTokenAuth(w, header)
+			}
+			if dbg {
+				return
+			}
+
+			if !token.HasScope(types.ScopeAdmin) {
+				user.IsAdmin = false
+			}
+
+			if !tokenAllows(token, r) {
+				respondWithError(w, http.StatusForbidden, "No access to this functionality (token scope).")
 				return
 			}
 
 			//Will be passed to the request function to avoid asking the SQL server again for the user
 			context.Set(r, "user", user)
+			context.Set(r, "token", token)
 			next.ServeHTTP(w, r)
 		})
 	}
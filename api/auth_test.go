@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/outout14/sacrebleu-api/api/types"
+)
+
+func TestRecordsScope(t *testing.T) {
+	a, admin := newTestServer(t)
+	bob, _ := newTestUser(t, a, "bob")
+	if code, body := a.request(admin, "POST", "/api/domain", `{"Fqdn":"example.org.","OwnerID":2}`); code != http.StatusOK {
+		t.Fatalf("can't create the domain : %d %s", code, body)
+	}
+	token := types.Token{UserID: bob.ID, Name: "records", Secret: GenerateToken(), Scopes: types.Scopes{"records:1"}}
+	if err := token.CreateToken(a.DB); err != nil {
+		t.Fatalf("can't create the token : %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		reply  string
+	}{
+		{"read", "GET", "/api/domain/1/records", ``, http.StatusOK, `"example.org."`},
+		{"create record", "POST", "/api/record", `{"DomainID":1,"Fqdn":"www.example.org.","Type":"A","TTL":300,"Content":"192.0.2.1"}`, http.StatusOK, ``},
+		{"zone import", "POST", "/api/domain/1/zone?dryRun=true", "www.example.org. 300 IN A 192.0.2.2\n", http.StatusOK, ``},
+		{"axfr import", "POST", "/api/domain/1/import/axfr", `{"Primary":"192.0.2.53"}`, http.StatusForbidden, "Primary server not allowed"},
+		{"domain update", "PUT", "/api/domain/1", `{"Description":"hello"}`, http.StatusForbidden, "token scope"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, body := a.request(token.Secret, test.method, test.path, test.body)
+			if code != test.code || !strings.Contains(body, test.reply) {
+				t.Errorf("got %d %s, want %d %s", code, body, test.code, test.reply)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import "time"
+
+//Auth : Struct for the authentication (JWT sessions) configuration in the config.ini file
+type Auth struct {
+	SigningMethod   string        //HS256 (default) or EdDSA
+	Key             string        //HMAC secret (HS256), at least 32 characters
+	KeyFile         string        //Ed25519 private key in a PEM file (EdDSA)
+	AccessLifetime  time.Duration //Lifetime of the access tokens (default 15m)
+	RefreshLifetime time.Duration //Lifetime of the sessions without refresh (default 720h)
+	Providers       []string      //Login providers tried for the unknown users, in order (default local). The known users use their own provider.
+}
+
+//Throttle : Struct for the login brute-force protection configuration in the config.ini file
+type Throttle struct {
+	Store              string        //memory (default, one API server) or sql (shared by the API servers)
+	Window             time.Duration //Duration the failed logins are counted (default 15m)
+	MaxIPFailures      int           //Failed logins from an IP before its lockout (default 20)
+	MaxAccountFailures int           //Failed logins of an account before its lockout (default 5)
+	Lockout            time.Duration //Duration of the lockout (default 15m)
+}
+
+//Mail : Struct for the mail sending (SMTP) configuration in the config.ini file
+type Mail struct {
+	Host     string //SMTP server (the mails are only logged if empty)
+	Port     int    //Default 25
+	Username string //SMTP authentication (optional)
+	Password string
+	From     string //Sender address
+	BaseURL  string //URL of the dashboard, used in the links of the mails (eg : https://dash.example.com)
+}
+
+//LDAP : Struct for the LDAP login provider configuration in the config.ini file
+type LDAP struct {
+	URL                string //ldap:// or ldaps:// URL of the server
+	StartTLS           bool
+	BindDN             string //Service account to search the users (optional with UserDNTemplate)
+	BindPassword       string
+	BaseDN             string //Base of the users search
+	UserFilter         string //Default (uid=%s)
+	UserDNTemplate     string //DN of the users when there is no service account (eg : uid=%s,ou=people,dc=example,dc=org)
+	UsernameAttribute  string //Default uid
+	EmailAttribute     string //Default mail
+	GroupBaseDN        string //Base of the groups search (no groups if empty)
+	GroupFilter        string //Default (member=%s), with the user DN
+	GroupNameAttribute string //Default cn
+	AdminGroup         string //Members of this group are admins (admin rights not managed by LDAP if empty)
+}
+
+//OIDC : Struct for the OpenID Connect single sign-on configuration in the config.ini file
+type OIDC struct {
+	Issuer            string //Issuer URL of the IdP (SSO disabled if empty)
+	ClientID          string
+	ClientSecret      string
+	RedirectURL       string   //Callback URL of the API (eg : https://api.example.com/api/oidc/callback)
+	Scopes            []string //Default openid, email, profile
+	UsernameClaim     string   //Default preferred_username
+	GroupsClaim       string   //Default groups
+	AdminGroup        string   //Members of this group are admins (admin rights not managed by the IdP if empty)
+	PostLoginURL      string   //Dashboard URL receiving the session tokens in its fragment (JSON reply if empty)
+	DisableLocalLogin bool     //Only allow the SSO (the password login is refused)
+}
+
+//ACME : Struct for the acme-dns compatible API (DNS-01 challenges) configuration in the config.ini file
+type ACME struct {
+	Domain           string //Zone of the challenge records, must be a domain of the API (eg : acme.example.org.) (disabled if empty)
+	OpenRegistration bool   //Allow the registrations without API token on /acme/register (as acme-dns does)
+	TTL              int    //TTL of the challenge records (default 1)
+}
+
+//DNSUpdate : Struct for the RFC 2136 dynamic updates listener configuration in the config.ini file
+type DNSUpdate struct {
+	Listen string   //Address of the UDP and TCP listeners (eg : 0.0.0.0:5353) (disabled if empty)
+	Keys   []string //TSIG keys as name:algorithm:base64 secret:username, the updates have the rights of the user (eg : dhcp.:hmac-sha256:c2VjcmV0:dhcp)
+}
+
+//XFR : Struct for the zone transfers (AXFR / IXFR) listener configuration in the config.ini file
+//The TSIG keys of the DNSUpdate section can transfer the zones their user can read
+type XFR struct {
+	Listen          string   //Address of the TCP and UDP listeners (eg : 0.0.0.0:5354) (disabled if empty)
+	ImportPrimaries []string //Networks of the primary servers the users can import zones from by AXFR (admins only if empty)
+}
+
+//Proxy : Struct for the reverse proxies configuration in the config.ini file
+type Proxy struct {
+	Trusted []string //Networks of the reverse proxies whose X-Forwarded-For and X-Real-IP headers give the client address (headers ignored if empty)
+}
+
+//Config : Struct for the API only sections of the config.ini file
+type Config struct {
+	Auth      Auth
+	Throttle  Throttle
+	Mail      Mail
+	OIDC      OIDC      `ini:"OIDC"`
+	LDAP      LDAP      `ini:"LDAP"`
+	ACME      ACME      `ini:"ACME"`
+	DNSUpdate DNSUpdate `ini:"DNSUpdate"`
+	XFR       XFR       `ini:"XFR"`
+	Proxy     Proxy
+}
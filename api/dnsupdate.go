@@ -0,0 +1,407 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+//dnsUpdater : RFC 2136 dynamic updates listener (UDP and TCP)
+type dnsUpdater struct {
+	db      *gorm.DB
+	addr    string
+	keyring *tsigKeyring
+}
+
+//newDNSUpdater : create the dynamic updates listener (nil if it is disabled)
+func newDNSUpdater(conf DNSUpdate, db *gorm.DB, keyring *tsigKeyring) *dnsUpdater {
+	if conf.Listen == "" {
+		return nil
+	}
+	return &dnsUpdater{db: db, addr: conf.Listen, keyring: keyring}
+}
+
+//acceptUpdate : accept the UPDATE messages (refused by the miekg/dns default function)
+func acceptUpdate(dh dns.Header) dns.MsgAcceptAction {
+	if int(dh.Bits>>11)&0xF == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+	return dns.DefaultMsgAcceptFunc(dh)
+}
+
+//listen : start the UDP and TCP listeners
+func (u *dnsUpdater) listen() {
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: u.addr, Net: network, Handler: u, TsigProvider: u.keyring, MsgAcceptFunc: acceptUpdate}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				logrus.Fatalf("DNSUPDATE : Can't listen on %s/%s : %s", server.Addr, server.Net, err)
+			}
+		}()
+	}
+	logrus.WithFields(logrus.Fields{"addr": u.addr}).Info("DNSUPDATE : Started")
+}
+
+//ServeDNS : answer an UPDATE message (signed with the request key, but the NOTAUTH answers as the clients expect)
+func (u *dnsUpdater) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Rcode = u.update(w, req)
+
+	if tsig := req.IsTsig(); tsig != nil && w.TsigStatus() == nil && m.Rcode != dns.RcodeNotAuth {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+	w.WriteMsg(m)
+}
+
+//update : authenticate, check and apply an UPDATE message, get the answer code
+func (u *dnsUpdater) update(w dns.ResponseWriter, req *dns.Msg) int {
+	if req.Opcode != dns.OpcodeUpdate {
+		return dns.RcodeNotImplemented
+	}
+	if len(req.Question) != 1 || req.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+	zone := dns.Fqdn(strings.ToLower(req.Question[0].Name))
+	source, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+	logger := logrus.WithFields(logrus.Fields{"zone": zone, "ip": source})
+
+	//Unsigned updates are refused, the key algorithm must be the configured one
+	tsig := req.IsTsig()
+	if tsig == nil {
+		logger.Info("DNSUPDATE : Unsigned update refused")
+		return dns.RcodeRefused
+	}
+	key, err := u.keyring.key(tsig.Hdr.Name)
+	if err != nil || w.TsigStatus() != nil {
+		logger.WithField("key", tsig.Hdr.Name).Info("DNSUPDATE : Authentication failed")
+		return dns.RcodeNotAuth
+	}
+	logger = logger.WithField("key", key.Name)
+
+	d := types.Domain{Fqdn: zone}
+	err = d.GetDomainByFqdn(u.db)
+	if err == gorm.ErrRecordNotFound {
+		return dns.RcodeNotAuth
+	}
+	if err != nil {
+		return dns.RcodeServerFailure
+	}
+
+	user, role, rules, rcode := u.keyAccess(key, d)
+	if rcode != dns.RcodeSuccess {
+		logger.Info("DNSUPDATE : No access to the zone")
+		return rcode
+	}
+
+	existing, err := d.GetDomainRecords(u.db, -1, -1)
+	if err != nil {
+		return dns.RcodeServerFailure
+	}
+
+	if rcode := checkPrerequisites(d, req.Answer, existing); rcode != dns.RcodeSuccess {
+		return rcode
+	}
+	wanted, rcode := applyUpdates(d, req.Ns, existing)
+	if rcode != dns.RcodeSuccess {
+		return rcode
+	}
+
+	changes := types.DiffZone(existing, wanted, true)
+	if changes.Empty() {
+		return dns.RcodeSuccess
+	}
+	if conflict := types.CheckChangesConflicts(d.Fqdn, existing, changes); conflict != nil {
+		logger.Infof("DNSUPDATE : %s", conflict.Message)
+		return dns.RcodeRefused
+	}
+	if !updateAllowed(role, rules, changes, existing) {
+		logger.Info("DNSUPDATE : No access to the records")
+		return dns.RcodeRefused
+	}
+
+	if err := d.ApplyChanges(u.db, user, "dns.update", changes); err != nil {
+		logger.Errorf("DNSUPDATE : Can't update the records : %s", err)
+		return dns.RcodeServerFailure
+	}
+
+	entry := types.AuditEntry{
+		UserID:     user.ID,
+		Action:     "dns.update",
+		Method:     "UPDATE",
+		Endpoint:   "dns://" + zone,
+		TargetType: "domain",
+		TargetID:   d.ID,
+		DomainID:   d.ID,
+		Status:     http.StatusOK, //HTTP status like the other entries (the answer is NOERROR)
+		SourceIP:   source,
+	}
+	if err := entry.CreateAuditEntry(u.db); err != nil {
+		logger.Errorf("AUDIT : Can't write entry : %s", err)
+	}
+
+	logger.WithFields(logrus.Fields{"created": len(changes.Created), "updated": len(changes.Updated), "deleted": len(changes.Deleted)}).Info("DNSUPDATE : Zone updated")
+	return dns.RcodeSuccess
+}
+
+//keyAccess : get the user of the key (the changes are logged with its name) and its role or permission rules on the domain
+//The keys of the database need the update permission on the domain, they have the rights of an editor (refused if their creator was deleted)
+func (u *dnsUpdater) keyAccess(key tsigKey, d types.Domain) (types.User, string, []types.RecordRule, int) {
+	if key.ID != 0 {
+		stored := types.TSIGKey{ID: key.ID}
+		if err := stored.GetTSIGKey(u.db); err != nil {
+			return types.User{}, "", nil, dns.RcodeServerFailure
+		}
+		allowed, err := stored.Allows(u.db, d.ID, types.TSIGUpdate)
+		if err != nil {
+			return types.User{}, "", nil, dns.RcodeServerFailure
+		}
+		if !allowed {
+			return types.User{}, "", nil, dns.RcodeRefused
+		}
+
+		user := types.User{ID: stored.UserID}
+		if err := user.GetUser(u.db); err == gorm.ErrRecordNotFound {
+			logrus.WithField("key", key.Name).Error("DNSUPDATE : The creator of the key doesn't exist anymore")
+			return user, "", nil, dns.RcodeRefused
+		} else if err != nil {
+			return user, "", nil, dns.RcodeServerFailure
+		}
+		return user, types.RoleEditor, nil, dns.RcodeSuccess
+	}
+
+	user := types.User{Username: key.Username}
+	if err := user.GetUserByUsername(u.db); err != nil {
+		logrus.WithField("key", key.Name).Errorf("DNSUPDATE : Can't get the user of the key : %s", err)
+		return user, "", nil, dns.RcodeRefused
+	}
+
+	//Editors can change every record, the others need permission rules
+	role, err := user.DomainRole(u.db, d)
+	if err != nil {
+		return user, "", nil, dns.RcodeServerFailure
+	}
+	var rules []types.RecordRule
+	if !types.RoleAllows(role, types.RoleEditor) {
+		if rules, err = types.GetSubjectRules(u.db, d.ID, user.ID, 0); err != nil {
+			return user, "", nil, dns.RcodeServerFailure
+		}
+		if len(rules) == 0 {
+			return user, "", nil, dns.RcodeRefused
+		}
+	}
+	return user, role, rules, dns.RcodeSuccess
+}
+
+//updateAllowed : check the changes against the role (NS and SOA need the manager role) or the permission rules
+func updateAllowed(role string, rules []types.RecordRule, changes types.ZoneChanges, existing []types.Record) bool {
+	if types.RoleAllows(role, types.RoleEditor) {
+		for _, record := range changes.Touched(existing) {
+			if types.ProtectedRecord(record) && !types.RoleAllows(role, types.RoleManager) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, record := range changes.Created {
+		if !types.RulesAllow(rules, types.ActionCreate, record) {
+			return false
+		}
+	}
+	for _, record := range changes.Updated {
+		if !types.RulesAllow(rules, types.ActionUpdate, record) {
+			return false
+		}
+	}
+	for _, record := range changes.Deleted {
+		if !types.RulesAllow(rules, types.ActionDelete, record) {
+			return false
+		}
+	}
+	return true
+}
+
+//rrsetOf : records of the name and type (every type if rrtype is ANY)
+func rrsetOf(records []types.Record, name string, rrtype uint16) []types.Record {
+	rrset := []types.Record{}
+	for _, record := range records {
+		if strings.EqualFold(record.Fqdn, name) && (rrtype == dns.TypeANY || uint16(record.Type) == rrtype) {
+			rrset = append(rrset, record)
+		}
+	}
+	return rrset
+}
+
+//checkPrerequisites : evaluate the prerequisite section of an UPDATE message (RFC 2136 section 3.2)
+func checkPrerequisites(d types.Domain, prereqs []dns.RR, existing []types.Record) int {
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	values := map[rrsetKey][]types.Record{}
+	order := []rrsetKey{}
+
+	for _, rr := range prereqs {
+		hdr := rr.Header()
+		name := strings.ToLower(hdr.Name)
+		if hdr.Ttl != 0 {
+			return dns.RcodeFormatError
+		}
+		if !dns.IsSubDomain(d.Fqdn, name) {
+			return dns.RcodeNotZone
+		}
+
+		switch hdr.Class {
+		case dns.ClassANY:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if len(rrsetOf(existing, name, hdr.Rrtype)) == 0 {
+				if hdr.Rrtype == dns.TypeANY {
+					return dns.RcodeNameError
+				}
+				return dns.RcodeNXRrset
+			}
+		case dns.ClassNONE:
+			if hdr.Rdlength != 0 {
+				return dns.RcodeFormatError
+			}
+			if len(rrsetOf(existing, name, hdr.Rrtype)) != 0 {
+				if hdr.Rrtype == dns.TypeANY {
+					return dns.RcodeYXDomain
+				}
+				return dns.RcodeYXRrset
+			}
+		case dns.ClassINET:
+			key := rrsetKey{name, hdr.Rrtype}
+			if _, ok := values[key]; !ok {
+				order = append(order, key)
+			}
+			values[key] = append(values[key], types.RecordFromRR(rr, d.ID))
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+
+	//Value dependent prerequisites : the RRset must be exactly the given one
+	for _, key := range order {
+		if !sameRRset(rrsetOf(existing, key.name, key.rrtype), values[key]) {
+			return dns.RcodeNXRrset
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+//sameRRset : check if both sets have the same records (TTL excluded)
+func sameRRset(a []types.Record, b []types.Record) bool {
+	contains := func(set []types.Record, record types.Record) bool {
+		for _, r := range set {
+			if types.SameRecord(r, record) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, record := range a {
+		if !contains(b, record) {
+			return false
+		}
+	}
+	for _, record := range b {
+		if !contains(a, record) {
+			return false
+		}
+	}
+	return true
+}
+
+//applyUpdates : check the update section of an UPDATE message (RFC 2136 section 3.4) and get the records of the zone once it is applied
+//The SOA is ignored (it is generated) and the NS of the apex can't all be deleted
+func applyUpdates(d types.Domain, updates []dns.RR, existing []types.Record) ([]types.Record, int) {
+	for _, rr := range updates {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(d.Fqdn, strings.ToLower(hdr.Name)) {
+			return nil, dns.RcodeNotZone
+		}
+
+		meta := types.RRType(hdr.Rrtype).Meta()
+		switch hdr.Class {
+		case dns.ClassINET:
+			if meta || hdr.Rrtype == dns.TypeANY {
+				return nil, dns.RcodeFormatError
+			}
+		case dns.ClassANY:
+			if hdr.Ttl != 0 || hdr.Rdlength != 0 || (meta && hdr.Rrtype != dns.TypeANY) {
+				return nil, dns.RcodeFormatError
+			}
+		case dns.ClassNONE:
+			if hdr.Ttl != 0 || meta || hdr.Rrtype == dns.TypeANY {
+				return nil, dns.RcodeFormatError
+			}
+		default:
+			return nil, dns.RcodeFormatError
+		}
+	}
+
+	wanted := []types.Record{}
+	for _, record := range existing {
+		if record.Type != 6 {
+			wanted = append(wanted, record)
+		}
+	}
+
+	for _, rr := range updates {
+		hdr := rr.Header()
+		name := strings.ToLower(hdr.Name)
+		apex := name == d.Fqdn
+		if hdr.Rrtype == dns.TypeSOA {
+			continue
+		}
+
+		switch hdr.Class {
+		case dns.ClassINET: //Add to an RRset (the TTL of an existing record is replaced)
+			record := types.RecordFromRR(rr, d.ID)
+			record.Fqdn = name
+			found := false
+			for i := range wanted {
+				if types.SameRecord(wanted[i], record) {
+					wanted[i].TTL = record.TTL
+					found = true
+				}
+			}
+			if !found {
+				wanted = append(wanted, record)
+			}
+		case dns.ClassANY: //Delete an RRset or all the RRsets of a name
+			kept := []types.Record{}
+			for _, record := range wanted {
+				deleted := strings.EqualFold(record.Fqdn, name) && (hdr.Rrtype == dns.TypeANY || uint16(record.Type) == hdr.Rrtype)
+				if !deleted || (apex && record.Type == 2) {
+					kept = append(kept, record)
+				}
+			}
+			wanted = kept
+		case dns.ClassNONE: //Delete a record from an RRset
+			record := types.RecordFromRR(rr, d.ID)
+			if apex && hdr.Rrtype == dns.TypeNS && len(rrsetOf(wanted, name, dns.TypeNS)) <= 1 {
+				continue
+			}
+			kept := []types.Record{}
+			for _, r := range wanted {
+				if !types.SameRecord(r, record) {
+					kept = append(kept, r)
+				}
+			}
+			wanted = kept
+		}
+	}
+	return wanted, dns.RcodeSuccess
+}
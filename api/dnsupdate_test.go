@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/outout14/sacrebleu-api/api/types"
+)
+
+var updateDomain = types.Domain{ID: 1, Fqdn: "example.org."}
+
+//updateZone : records of the zone the tests update
+func updateZone() []types.Record {
+	return []types.Record{
+		{ID: 1, DomainID: 1, Fqdn: "example.org.", Type: 6, TTL: 3600, Content: "ns1.example.net. hostmaster.example.org. 2021011801 7200 3600 1209600 3600"},
+		{ID: 2, DomainID: 1, Fqdn: "example.org.", Type: 2, TTL: 3600, Content: "ns1.example.net."},
+		{ID: 3, DomainID: 1, Fqdn: "example.org.", Type: 2, TTL: 3600, Content: "ns2.example.net."},
+		{ID: 4, DomainID: 1, Fqdn: "example.org.", Type: 1, TTL: 3600, Content: "192.0.2.1"},
+		{ID: 5, DomainID: 1, Fqdn: "www.example.org.", Type: 1, TTL: 3600, Content: "192.0.2.2"},
+		{ID: 6, DomainID: 1, Fqdn: "www.example.org.", Type: 16, TTL: 3600, Content: `"hello"`},
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("invalid RR %q : %s", s, err)
+	}
+	return rr
+}
+
+//recordKeys : sorted "name type content" of the records
+func recordKeys(records []types.Record) []string {
+	keys := []string{}
+	for _, r := range records {
+		keys = append(keys, fmt.Sprintf("%s %v %s", r.Fqdn, r.Type, r.Content))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCheckPrerequisites(t *testing.T) {
+	tests := []struct {
+		name    string
+		prereqs func(m *dns.Msg)
+		rcode   int
+	}{
+		{"none", func(m *dns.Msg) {}, dns.RcodeSuccess},
+		{"rrset exists", func(m *dns.Msg) { m.RRsetUsed([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeSuccess},
+		{"rrset doesn't exist (NXRRSET)", func(m *dns.Msg) { m.RRsetUsed([]dns.RR{mustRR(t, "www.example.org. AAAA ::")}) }, dns.RcodeNXRrset},
+		{"rrset exists (YXRRSET)", func(m *dns.Msg) { m.RRsetNotUsed([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeYXRrset},
+		{"rrset doesn't exist", func(m *dns.Msg) { m.RRsetNotUsed([]dns.RR{mustRR(t, "www.example.org. AAAA ::")}) }, dns.RcodeSuccess},
+		{"name in use", func(m *dns.Msg) { m.NameUsed([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeSuccess},
+		{"name not in use (NXDOMAIN)", func(m *dns.Msg) { m.NameUsed([]dns.RR{mustRR(t, "mail.example.org. A 0.0.0.0")}) }, dns.RcodeNameError},
+		{"name in use (YXDOMAIN)", func(m *dns.Msg) { m.NameNotUsed([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeYXDomain},
+		{"name not in use", func(m *dns.Msg) { m.NameNotUsed([]dns.RR{mustRR(t, "mail.example.org. A 0.0.0.0")}) }, dns.RcodeSuccess},
+		{"same rrset", func(m *dns.Msg) {
+			m.Used([]dns.RR{mustRR(t, "www.example.org. 0 A 192.0.2.2")})
+		}, dns.RcodeSuccess},
+		{"other rrset (NXRRSET)", func(m *dns.Msg) {
+			m.Used([]dns.RR{mustRR(t, "www.example.org. 0 A 192.0.2.2"), mustRR(t, "www.example.org. 0 A 192.0.2.3")})
+		}, dns.RcodeNXRrset},
+		{"ttl not zero", func(m *dns.Msg) { m.Answer = append(m.Answer, mustRR(t, "www.example.org. 300 IN A 192.0.2.2")) }, dns.RcodeFormatError},
+		{"out of zone", func(m *dns.Msg) { m.RRsetUsed([]dns.RR{mustRR(t, "www.example.com. A 0.0.0.0")}) }, dns.RcodeNotZone},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetUpdate(updateDomain.Fqdn)
+			test.prereqs(m)
+			if rcode := checkPrerequisites(updateDomain, m.Answer, updateZone()); rcode != test.rcode {
+				t.Errorf("got %s, want %s", dns.RcodeToString[rcode], dns.RcodeToString[test.rcode])
+			}
+		})
+	}
+}
+
+func TestApplyUpdates(t *testing.T) {
+	zone := []string{
+		"example.org. A 192.0.2.1",
+		"example.org. NS ns1.example.net.",
+		"example.org. NS ns2.example.net.",
+		`www.example.org. TXT "hello"`,
+		"www.example.org. A 192.0.2.2",
+	}
+	without := func(removed ...string) []string {
+		kept := []string{}
+		for _, key := range zone {
+			found := false
+			for _, r := range removed {
+				found = found || r == key
+			}
+			if !found {
+				kept = append(kept, key)
+			}
+		}
+		return kept
+	}
+
+	tests := []struct {
+		name    string
+		updates func(m *dns.Msg)
+		rcode   int
+		wanted  []string
+	}{
+		{"add", func(m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "www.example.org. 300 AAAA 2001:db8::1")}) }, dns.RcodeSuccess,
+			append(without(), "www.example.org. AAAA 2001:db8::1")},
+		{"add existing", func(m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "www.example.org. 300 A 192.0.2.2")}) }, dns.RcodeSuccess,
+			without()},
+		{"soa ignored", func(m *dns.Msg) {
+			m.Insert([]dns.RR{mustRR(t, "example.org. 300 SOA ns.example.com. root.example.com. 1 2 3 4 5")})
+		}, dns.RcodeSuccess, without()},
+		{"delete rrset", func(m *dns.Msg) { m.RemoveRRset([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeSuccess,
+			without("www.example.org. A 192.0.2.2")},
+		{"delete name", func(m *dns.Msg) { m.RemoveName([]dns.RR{mustRR(t, "www.example.org. A 0.0.0.0")}) }, dns.RcodeSuccess,
+			without("www.example.org. A 192.0.2.2", `www.example.org. TXT "hello"`)},
+		{"delete name at the apex keeps the NS", func(m *dns.Msg) { m.RemoveName([]dns.RR{mustRR(t, "example.org. A 0.0.0.0")}) }, dns.RcodeSuccess,
+			without("example.org. A 192.0.2.1")},
+		{"delete NS rrset at the apex", func(m *dns.Msg) { m.RemoveRRset([]dns.RR{mustRR(t, "example.org. NS .")}) }, dns.RcodeSuccess,
+			without()},
+		{"delete record", func(m *dns.Msg) { m.Remove([]dns.RR{mustRR(t, "www.example.org. A 192.0.2.2")}) }, dns.RcodeSuccess,
+			without("www.example.org. A 192.0.2.2")},
+		{"delete an NS at the apex", func(m *dns.Msg) { m.Remove([]dns.RR{mustRR(t, "example.org. NS ns1.example.net.")}) }, dns.RcodeSuccess,
+			without("example.org. NS ns1.example.net.")},
+		{"delete the last NS at the apex", func(m *dns.Msg) {
+			m.Remove([]dns.RR{mustRR(t, "example.org. NS ns1.example.net."), mustRR(t, "example.org. NS ns2.example.net.")})
+		}, dns.RcodeSuccess, without("example.org. NS ns1.example.net.")},
+		{"out of zone", func(m *dns.Msg) { m.Insert([]dns.RR{mustRR(t, "www.example.com. 300 A 192.0.2.2")}) }, dns.RcodeNotZone, nil},
+		{"add ANY", func(m *dns.Msg) {
+			m.Insert([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: "www.example.org.", Rrtype: dns.TypeANY, Class: dns.ClassINET, Ttl: 300}}})
+		}, dns.RcodeFormatError, nil},
+		{"delete with a ttl", func(m *dns.Msg) {
+			m.Ns = append(m.Ns, &dns.ANY{Hdr: dns.RR_Header{Name: "www.example.org.", Rrtype: dns.TypeA, Class: dns.ClassANY, Ttl: 300}})
+		}, dns.RcodeFormatError, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := new(dns.Msg)
+			m.SetUpdate(updateDomain.Fqdn)
+			test.updates(m)
+			wanted, rcode := applyUpdates(updateDomain, m.Ns, updateZone())
+			if rcode != test.rcode {
+				t.Fatalf("got %s, want %s", dns.RcodeToString[rcode], dns.RcodeToString[test.rcode])
+			}
+			if rcode != dns.RcodeSuccess {
+				return
+			}
+			sort.Strings(test.wanted)
+			if keys := recordKeys(wanted); !reflect.DeepEqual(keys, test.wanted) {
+				t.Errorf("got %q, want %q", keys, test.wanted)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//errLDAPIdentityTaken : the username (or the email) of the directory is already used by another account
+var errLDAPIdentityTaken = errors.New("username or email already used by another account")
+
+//ldapProvider : Users checked with a bind on a LDAP server (created at their first login)
+type ldapProvider struct {
+	conf LDAP
+}
+
+func newLDAPProvider(conf LDAP) *ldapProvider {
+	if conf.UserFilter == "" {
+		conf.UserFilter = "(uid=%s)"
+	}
+	if conf.UsernameAttribute == "" {
+		conf.UsernameAttribute = "uid"
+	}
+	if conf.EmailAttribute == "" {
+		conf.EmailAttribute = "mail"
+	}
+	if conf.GroupFilter == "" {
+		conf.GroupFilter = "(member=%s)"
+	}
+	if conf.GroupNameAttribute == "" {
+		conf.GroupNameAttribute = "cn"
+	}
+	return &ldapProvider{conf: conf}
+}
+
+//escapeDN : escape a value of a DN (RFC 4514)
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+//connect : open a connection to the LDAP server
+func (p *ldapProvider) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(p.conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	if p.conf.StartTLS {
+		u, _ := url.Parse(p.conf.URL)
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+//searchOne : search a single entry
+func searchOne(conn *ldap.Conn, base string, scope int, filter string, attributes []string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 2, 0, false, filter, attributes, nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, errInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+//userDN : find the DN of the user (with the service account or the DN template)
+func (p *ldapProvider) userDN(conn *ldap.Conn, username string) (string, error) {
+	if p.conf.BindDN == "" {
+		if p.conf.UserDNTemplate == "" {
+			return "", errors.New("LDAP needs a BindDN or a UserDNTemplate")
+		}
+		return fmt.Sprintf(p.conf.UserDNTemplate, escapeDN(username)), nil
+	}
+
+	if err := conn.Bind(p.conf.BindDN, p.conf.BindPassword); err != nil {
+		return "", err
+	}
+	entry, err := searchOne(conn, p.conf.BaseDN, ldap.ScopeWholeSubtree, fmt.Sprintf(p.conf.UserFilter, ldap.EscapeFilter(username)), []string{"dn"})
+	if err != nil {
+		return "", err
+	}
+	return entry.DN, nil
+}
+
+//groups : names of the groups of the user
+func (p *ldapProvider) groups(conn *ldap.Conn, dn string) ([]string, error) {
+	if p.conf.GroupBaseDN == "" {
+		return nil, nil
+	}
+	filter := fmt.Sprintf(p.conf.GroupFilter, ldap.EscapeFilter(dn))
+	result, err := conn.Search(ldap.NewSearchRequest(p.conf.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, []string{p.conf.GroupNameAttribute}, nil))
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.GetAttributeValue(p.conf.GroupNameAttribute))
+	}
+	return groups, nil
+}
+
+func (p *ldapProvider) Authenticate(db *gorm.DB, user types.User, username string, password string) (types.User, error) {
+	conn, err := p.connect()
+	if err != nil {
+		return user, err
+	}
+	defer conn.Close()
+
+	dn, err := p.userDN(conn, username)
+	if err != nil {
+		return user, err
+	}
+
+	//Bind as the user to check the password
+	err = conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return user, errInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+
+	entry, err := searchOne(conn, dn, ldap.ScopeBaseObject, "(objectClass=*)", []string{p.conf.UsernameAttribute, p.conf.EmailAttribute})
+	if err != nil {
+		return user, err
+	}
+	groups, err := p.groups(conn, dn)
+	if err != nil {
+		return user, err
+	}
+
+	//The user may have been renamed in the directory
+	if user.ID == 0 {
+		user = types.User{Provider: types.ProviderLDAP, ExternalID: dn}
+		if err := user.GetUserByExternalID(db); err != nil && err != gorm.ErrRecordNotFound {
+			return user, err
+		}
+	}
+	if user.ID != 0 && user.Provider != types.ProviderLDAP {
+		return user, errInvalidCredentials
+	}
+
+	//Sync the user with the directory
+	user.Provider = types.ProviderLDAP
+	user.ExternalID = dn
+	if name := entry.GetAttributeValue(p.conf.UsernameAttribute); name != "" {
+		user.Username = name
+	} else if user.Username == "" {
+		user.Username = username
+	}
+	if email := entry.GetAttributeValue(p.conf.EmailAttribute); email != "" {
+		user.Email = email
+		user.EmailVerified = true
+	}
+	if identityTaken(db, user) { //A rename in the directory can't take over another account
+		return user, errLDAPIdentityTaken
+	}
+	if p.conf.AdminGroup != "" {
+		user.IsAdmin = false
+		for _, group := range groups {
+			if strings.EqualFold(group, p.conf.AdminGroup) {
+				user.IsAdmin = true
+			}
+		}
+	}
+
+	if user.ID == 0 { //Just-in-time creation
+		user.Password, err = HashPassword(GenerateToken()) //The password stays in the directory
+		if err != nil {
+			return user, err
+		}
+		err = user.CreateUser(db)
+		if err == gorm.ErrRegistered {
+			return user, errInvalidCredentials
+		}
+		return user, err
+	}
+	return user, user.UpdateUser(db)
+}
+
+//identityTaken : check if another account has the username or the email of the user
+func identityTaken(db *gorm.DB, u types.User) bool {
+	other := types.User{Username: u.Username}
+	if other.UsernameExists(db) && other.ID != u.ID {
+		return true
+	}
+	other = types.User{Email: u.Email}
+	return u.Email != "" && other.EmailExists(db) && other.ID != u.ID
+}
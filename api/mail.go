@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//Mailer : Send the mails of the API (password reset, email verification)
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+//newMailer : create the mailer from the [Mail] configuration
+func newMailer(conf Mail) Mailer {
+	if conf.Host == "" {
+		logrus.Warning("MAIL : No SMTP server in the [Mail] configuration, the mails are only logged.")
+		return logMailer{}
+	}
+	if conf.Port == 0 {
+		conf.Port = 25
+	}
+	return smtpMailer{conf: conf}
+}
+
+//smtpMailer : Send the mails with a SMTP server (STARTTLS is used when available)
+type smtpMailer struct {
+	conf Mail
+}
+
+func (m smtpMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.conf.Username != "" {
+		auth = smtp.PlainAuth("", m.conf.Username, m.conf.Password, m.conf.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.conf.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	addr := fmt.Sprintf("%s:%v", m.conf.Host, m.conf.Port)
+	return smtp.SendMail(addr, auth, m.conf.From, []string{to}, []byte(msg))
+}
+
+//logMailer : Only log the mails (when no SMTP server is configured)
+type logMailer struct{}
+
+func (logMailer) Send(to string, subject string, body string) error {
+	logrus.WithFields(logrus.Fields{"to": to, "subject": subject}).Infof("MAIL : %s", body)
+	return nil
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcCookie = "sacrebleu_oidc"
+
+//errOIDCUsername : the username (or the email) of the IdP is already used by another account
+var errOIDCUsername = errors.New("username or email already used by another account")
+
+//errOIDCLinked : the IdP account is already linked to another account
+var errOIDCLinked = errors.New("identity already linked to another account")
+
+//oidcClient : OpenID Connect client (the IdP discovery is done at the first use)
+type oidcClient struct {
+	conf     OIDC
+	mutex    sync.Mutex
+	verifier *oidc.IDTokenVerifier
+	oauth2   oauth2.Config
+	links    pendingLogins //Accounts to link, by login state
+	tickets  pendingLogins //Logins waiting for the TOTP code, by ticket
+}
+
+//pendingLogin : User of a login in progress
+type pendingLogin struct {
+	userID  int
+	expires time.Time
+}
+
+//pendingLogins : Single use tickets of the logins in progress (kept in memory, the expired ones are swept at each new ticket)
+type pendingLogins struct {
+	mutex  sync.Mutex
+	logins map[string]pendingLogin
+}
+
+//add : keep the ticket of the user until its expiration
+func (p *pendingLogins) add(ticket string, userID int, lifetime time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.logins == nil {
+		p.logins = make(map[string]pendingLogin)
+	}
+	now := time.Now()
+	for key, login := range p.logins {
+		if login.expires.Before(now) {
+			delete(p.logins, key)
+		}
+	}
+	p.logins[ticket] = pendingLogin{userID: userID, expires: now.Add(lifetime)}
+}
+
+//take : get the user of the ticket and forget it (false if it is unknown or expired)
+func (p *pendingLogins) take(ticket string) (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	login, ok := p.logins[ticket]
+	delete(p.logins, ticket)
+	if !ok || login.expires.Before(time.Now()) {
+		return 0, false
+	}
+	return login.userID, true
+}
+
+//newOIDCClient : create the client from the [OIDC] configuration (nil if the SSO is disabled)
+func newOIDCClient(conf OIDC) *oidcClient {
+	if conf.Issuer == "" {
+		return nil
+	}
+	if len(conf.Scopes) == 0 {
+		conf.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	if conf.UsernameClaim == "" {
+		conf.UsernameClaim = "preferred_username"
+	}
+	if conf.GroupsClaim == "" {
+		conf.GroupsClaim = "groups"
+	}
+	return &oidcClient{conf: conf}
+}
+
+//init : discover the IdP endpoints and keys
+func (c *oidcClient) init(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.verifier != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.conf.Issuer)
+	if err != nil {
+		return err
+	}
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.conf.ClientID})
+	c.oauth2 = oauth2.Config{
+		ClientID:     c.conf.ClientID,
+		ClientSecret: c.conf.ClientSecret,
+		RedirectURL:  c.conf.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.conf.Scopes,
+	}
+	return nil
+}
+
+//pkceChallenge : S256 code challenge of the PKCE verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//authURL : URL of the IdP login page
+func (c *oidcClient) authURL(state string, nonce string, verifier string) string {
+	return c.oauth2.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	)
+}
+
+//oidcClaims : Claims of the ID token used to map the user
+type oidcClaims struct {
+	Issuer        string
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+//exchange : exchange the authorization code and verify the ID token
+func (c *oidcClient) exchange(ctx context.Context, code string, nonce string, verifier string) (oidcClaims, error) {
+	token, err := c.oauth2.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return oidcClaims{}, errors.New("no id_token in the token response")
+	}
+	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	if idToken.Nonce != nonce {
+		return oidcClaims{}, errors.New("invalid nonce")
+	}
+
+	var raw map[string]interface{}
+	if err := idToken.Claims(&raw); err != nil {
+		return oidcClaims{}, err
+	}
+	claims := oidcClaims{Issuer: idToken.Issuer, Subject: idToken.Subject}
+	claims.Email, _ = raw["email"].(string)
+	claims.EmailVerified, _ = raw["email_verified"].(bool)
+	claims.Username, _ = raw[c.conf.UsernameClaim].(string)
+	if claims.Username == "" {
+		claims.Username = claims.Email
+	}
+	switch groups := raw[c.conf.GroupsClaim].(type) {
+	case string:
+		claims.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				claims.Groups = append(claims.Groups, name)
+			}
+		}
+	}
+	if claims.Username == "" {
+		return claims, fmt.Errorf("no %s nor email claim", c.conf.UsernameClaim)
+	}
+	return claims, nil
+}
+
+//externalID : ID of the user at the IdP
+func (c oidcClaims) externalID() string {
+	return c.Issuer + "|" + c.Subject
+}
+
+//user : get the user linked to the claims (by issuer and subject), created at its first login (just-in-time) and updated with the claims
+//Existing accounts are never linked by their email, their owners link them from their session (link)
+func (c *oidcClient) user(db *gorm.DB, claims oidcClaims) (types.User, error) {
+	u := types.User{Provider: types.ProviderOIDC, ExternalID: claims.externalID()}
+	err := u.GetUserByExternalID(db)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return u, err
+	}
+
+	if err == gorm.ErrRecordNotFound {
+		u = types.User{Username: claims.Username}
+		if u.UsernameExists(db) {
+			return u, errOIDCUsername
+		}
+		u = types.User{Username: claims.Username, Provider: types.ProviderOIDC, ExternalID: claims.externalID()}
+		u.Password, err = HashPassword(GenerateToken()) //No password login
+		if err != nil {
+			return u, err
+		}
+	}
+
+	if err := c.update(db, &u, claims); err != nil {
+		return u, err
+	}
+
+	if u.ID == 0 { //Just-in-time creation
+		err = u.CreateUser(db)
+		if err == gorm.ErrRegistered {
+			return u, errOIDCUsername
+		}
+		return u, err
+	}
+	err = u.UpdateUser(db)
+	return u, err
+}
+
+//link : link an existing account to the claims (asked by its owner), its password login is replaced by the single sign-on
+func (c *oidcClient) link(db *gorm.DB, userID int, claims oidcClaims) (types.User, error) {
+	linked := types.User{Provider: types.ProviderOIDC, ExternalID: claims.externalID()}
+	err := linked.GetUserByExternalID(db)
+	if err == nil && linked.ID != userID {
+		return linked, errOIDCLinked
+	}
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return linked, err
+	}
+
+	u := types.User{ID: userID}
+	if err := u.GetUser(db); err != nil {
+		return u, err
+	}
+	u.Provider = types.ProviderOIDC
+	u.ExternalID = claims.externalID()
+	if err := c.update(db, &u, claims); err != nil {
+		return u, err
+	}
+	err = u.UpdateUser(db)
+	return u, err
+}
+
+//update : set the email and the admin role of the user from the claims
+//The email is kept if another account uses the one of the IdP (the new users are refused)
+func (c *oidcClient) update(db *gorm.DB, u *types.User, claims oidcClaims) error {
+	if claims.Email != "" && claims.Email != u.Email {
+		other := types.User{Email: claims.Email}
+		if other.EmailExists(db) && other.ID != u.ID {
+			if u.ID == 0 {
+				return errOIDCUsername
+			}
+			logrus.WithFields(logrus.Fields{"user": u.ID}).Warning("OIDC : The email of the IdP is used by another account, not updated")
+		} else {
+			u.Email = claims.Email
+			u.EmailVerified = claims.EmailVerified
+		}
+	} else if claims.Email != "" {
+		u.EmailVerified = claims.EmailVerified
+	}
+
+	if c.conf.AdminGroup != "" {
+		u.IsAdmin = false
+		for _, group := range claims.Groups {
+			if strings.EqualFold(group, c.conf.AdminGroup) {
+				u.IsAdmin = true
+			}
+		}
+	}
+	return nil
+}
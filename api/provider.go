@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/outout14/sacrebleu-api/api/types"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+//errInvalidCredentials : the username or the password is wrong
+var errInvalidCredentials = errors.New("invalid credentials")
+
+//AuthProvider : Check the username and password of the users (login)
+type AuthProvider interface {
+	//Authenticate : check the credentials and return the user, created or updated if the provider manages it
+	//user is the existing user with this username (ID 0 if unknown)
+	Authenticate(db *gorm.DB, user types.User, username string, password string) (types.User, error)
+}
+
+//localProvider : Users with their password hash in the database
+type localProvider struct{}
+
+func (localProvider) Authenticate(db *gorm.DB, user types.User, username string, password string) (types.User, error) {
+	if user.ID == 0 {
+		return user, errInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return user, errInvalidCredentials
+	}
+	return user, nil
+}
+
+//newAuthProviders : create the login providers (local and the configured ones)
+func newAuthProviders(conf *Config) (map[string]AuthProvider, error) {
+	providers := map[string]AuthProvider{types.ProviderLocal: localProvider{}}
+	if conf.LDAP.URL != "" {
+		providers[types.ProviderLDAP] = newLDAPProvider(conf.LDAP)
+	}
+
+	if len(conf.Auth.Providers) == 0 {
+		conf.Auth.Providers = []string{types.ProviderLocal}
+	}
+	for _, name := range conf.Auth.Providers {
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("unknown or not configured login provider %q", name)
+		}
+	}
+	return providers, nil
+}
+
+//authenticate : check the credentials with the provider of the user, or with the configured providers for the unknown users
+func (a *Server) authenticate(username string, password string) (types.User, error) {
+	if password == "" { //Avoid LDAP unauthenticated binds
+		return types.User{}, errInvalidCredentials
+	}
+
+	user := types.User{Username: username}
+	err := user.GetUserByUsername(a.DB)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return user, err
+	}
+
+	if err == nil {
+		provider, ok := a.providers[user.Provider]
+		if !ok { //eg : single sign-on users
+			return user, errInvalidCredentials
+		}
+		return provider.Authenticate(a.DB, user, username, password)
+	}
+
+	//Unknown user : it can be created by a provider (just-in-time)
+	for _, name := range a.Config.Auth.Providers {
+		user, err := a.providers[name].Authenticate(a.DB, types.User{}, username, password)
+		if err != errInvalidCredentials {
+			return user, err
+		}
+	}
+	return types.User{}, errInvalidCredentials
+}
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+//acmeTXTLength : length of the DNS-01 challenge values (base64url SHA-256 digest)
+const acmeTXTLength = 43
+
+//ACMEAccount : Credentials of an acme-dns registration (only returned at creation, same format as acme-dns)
+type ACMEAccount struct {
+	Username   string   `json:"username" example:"c36f50e8-4632-44f0-83fe-e070fef28a10"`
+	Password   string   `json:"password" example:"htB9mR9DYgcu9bX_afHF62erXaH2TS7bg9KW3F7Z"`
+	Fulldomain string   `json:"fulldomain" example:"8e5700ea-a4bf-41c7-8a77-e990661dcc6a.acme.example.org"`
+	Subdomain  string   `json:"subdomain" example:"8e5700ea-a4bf-41c7-8a77-e990661dcc6a"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+//ACMEUpdate : Challenge value sent by an acme-dns client
+type ACMEUpdate struct {
+	Subdomain string `json:"subdomain" example:"8e5700ea-a4bf-41c7-8a77-e990661dcc6a"`
+	TXT       string `json:"txt" example:"LHDhK3oGRvkiefQnx7OOczTY5Tic_xZ6HcMOc_gmtoM"`
+}
+
+//respondWithACMEError : error in the acme-dns format (the clients expect it)
+func respondWithACMEError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, map[string]string{"error": message})
+}
+
+//acmeDomain : get the zone of the challenge records
+func (a *Server) acmeDomain(w http.ResponseWriter) (types.Domain, bool) {
+	if a.Config.ACME.Domain == "" {
+		respondWithACMEError(w, http.StatusNotFound, "acme_disabled")
+		return types.Domain{}, true
+	}
+
+	d := types.Domain{Fqdn: strings.TrimSuffix(a.Config.ACME.Domain, ".") + "."}
+	err := d.GetDomainByFqdn(a.DB)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"domain": d.Fqdn, "error": err}).Error("ACME : Can't get the challenges zone")
+		respondWithACMEError(w, http.StatusInternalServerError, "acme_zone_not_found")
+		return d, true
+	}
+	return d, false
+}
+
+//acmeCreateRegistration : create the registration of an acme-dns client (userID is 0 for the open registrations)
+func (a *Server) acmeCreateRegistration(w http.ResponseWriter, r *http.Request, userID int) {
+	d, dbg := a.acmeDomain(w)
+	if dbg {
+		return
+	}
+
+	//The body is optional
+	var payload struct {
+		AllowFrom types.Networks `json:"allowfrom"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil && err != io.EOF {
+		respondWithACMEError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := payload.AllowFrom.Validate(); err != nil {
+		respondWithACMEError(w, http.StatusBadRequest, "invalid_allowfrom_cidr")
+		return
+	}
+
+	reg := types.ACMERegistration{
+		Username:  generateUUID(),
+		Password:  GenerateToken(),
+		Subdomain: generateUUID(),
+		AllowFrom: payload.AllowFrom,
+		UserID:    userID,
+	}
+	if reg.AllowFrom == nil {
+		reg.AllowFrom = types.Networks{}
+	}
+	err := reg.CreateRegistration(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, ACMEAccount{
+		Username:   reg.Username,
+		Password:   reg.Password,
+		Fulldomain: strings.TrimSuffix(reg.Fqdn(d), "."),
+		Subdomain:  reg.Subdomain,
+		AllowFrom:  reg.AllowFrom,
+	})
+}
+
+// acmeRegister endpoint.
+// @Security ApiKeyAuth
+// @Summary Register acme-dns client
+// @Description Create acme-dns credentials allowed to write the TXT records of a new name in the ACME zone only.
+// @Description Point _acme-challenge.<name> with a CNAME to the returned fulldomain. The password is only returned in this response.
+// @ID acmeregister
+// @Accept  json
+// @Produce  json
+// @Success 201 {object} ACMEAccount
+// @Failure 400,403,404 {object} Response
+// @Tags ACME
+// @Router /acme/register [post]
+func (a *Server) acmeRegister(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	a.acmeCreateRegistration(w, r, user.ID)
+}
+
+//acmeOpenRegister : acme-dns /register endpoint (without API token, if the open registration is allowed)
+func (a *Server) acmeOpenRegister(w http.ResponseWriter, r *http.Request) {
+	if !a.Config.ACME.OpenRegistration {
+		respondWithACMEError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	a.acmeCreateRegistration(w, r, 0)
+}
+
+//acmeUpdate : acme-dns /update endpoint, authenticated by the X-Api-User and X-Api-Key headers
+func (a *Server) acmeUpdate(w http.ResponseWriter, r *http.Request) {
+	d, dbg := a.acmeDomain(w)
+	if dbg {
+		return
+	}
+
+	reg := types.ACMERegistration{Username: r.Header.Get("X-Api-User")}
+	err := reg.GetRegistrationByUsername(a.DB)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		checkSrvErr(err, w)
+		return
+	}
+	if err != nil || !reg.CheckPassword(r.Header.Get("X-Api-Key")) || !reg.AllowFrom.Contains(a.sourceIP(r)) {
+		logrus.WithFields(logrus.Fields{"username": reg.Username, "ip": a.sourceIP(r)}).Info("ACME : Update refused")
+		respondWithACMEError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+
+	var update ACMEUpdate
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&update); err != nil {
+		respondWithACMEError(w, http.StatusBadRequest, "malformed_json_payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if update.Subdomain != reg.Subdomain {
+		respondWithACMEError(w, http.StatusUnauthorized, "forbidden")
+		return
+	}
+	if !validACMETXT(update.TXT) {
+		respondWithACMEError(w, http.StatusBadRequest, "bad_txt")
+		return
+	}
+
+	//The changes are made (and logged in the domain history) as the owner of the ACME zone
+	owner := types.User{ID: d.OwnerID}
+	if err := owner.GetUser(a.DB); err != nil && err != gorm.ErrRecordNotFound {
+		checkSrvErr(err, w)
+		return
+	}
+
+	ttl := a.Config.ACME.TTL
+	if ttl <= 0 {
+		ttl = 1
+	}
+	err = reg.UpdateChallenge(a.DB, d, owner, update.TXT, ttl)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{"txt": update.TXT})
+}
+
+//validACMETXT : check the challenge value (43 base64url characters)
+func validACMETXT(txt string) bool {
+	if len(txt) != acmeTXTLength {
+		return false
+	}
+	for _, c := range txt {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//acmeHealth : acme-dns /health endpoint
+func (a *Server) acmeHealth(w http.ResponseWriter, r *http.Request) {
+	respondWithCode(w, http.StatusOK)
+}
+
+// getACMERegistrations endpoint.
+// @Security ApiKeyAuth
+// @Summary Get acme-dns registrations
+// @Description List the acme-dns registrations of the user (all of them for the admins, the open registrations have no user)
+// @ID acmeregistrations
+// @Produce  json
+// @Success 200 {object} []types.ACMERegistration
+// @Failure 400,403 {object} Response
+// @Tags ACME
+// @Router /acme/registrations [get]
+func (a *Server) getACMERegistrations(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	registrations, err := types.GetRegistrations(a.DB, user)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, registrations)
+}
+
+// deleteACMERegistration endpoint.
+// @Security ApiKeyAuth
+// @Summary Delete acme-dns registration
+// @Description Revoke acme-dns credentials and delete their TXT records
+// @ID delacmeregistration
+// @Produce  json
+// @Param   registration_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags ACME
+// @Router /acme/registration/{registration_id} [delete]
+func (a *Server) deleteACMERegistration(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	reg := types.ACMERegistration{ID: id}
+	err := reg.GetRegistration(a.DB)
+	if err == gorm.ErrRecordNotFound || (err == nil && reg.UserID != user.ID && !user.IsAdmin) {
+		respondWithError(w, http.StatusNotFound, "Registration not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		d := types.Domain{Fqdn: strings.TrimSuffix(a.Config.ACME.Domain, ".") + "."}
+		if a.Config.ACME.Domain != "" && d.GetDomainByFqdn(tx) == nil {
+			if err := reg.DeleteChallenges(tx, d, user); err != nil {
+				return err
+			}
+		}
+		return reg.DeleteRegistration(tx)
+	})
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
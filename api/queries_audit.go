@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+)
+
+// getAudit endpoint.
+// @Security ApiKeyAuth
+// @Summary Search the audit log
+// @Description Get the authenticated API actions (newest first), admin only
+// @ID audit
+// @Produce  json
+// @Param   user      query   int     false  "2"
+// @Param   domain      query   int     false  "1"
+// @Param   action      query   string     false  "record.delete"
+// @Param   from      query   string     false  "2021-01-01T00:00:00Z"
+// @Param   to      query   string     false  "2021-02-01T00:00:00Z"
+// @Param   count      query   int     false  "10"
+// @Param   start      query   int     false  "1"
+// @Success 200 {object} []types.AuditEntry
+// @Failure 400,403 {object} Response
+// @Tags Audit
+// @Router /audit [get]
+func (a *Server) getAudit(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin { //Non-admin can't read the audit log !
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return
+	}
+
+	//Parsing request vars
+	vars := r.URL.Query()
+	count, _ := strconv.Atoi(vars.Get("count"))
+	start, _ := strconv.Atoi(vars.Get("start"))
+	count = calcCount(count)
+	start = calcStart(start)
+
+	var filter types.AuditFilter
+	filter.UserID, _ = strconv.Atoi(vars.Get("user"))
+	filter.DomainID, _ = strconv.Atoi(vars.Get("domain"))
+	filter.Action = vars.Get("action")
+
+	var err error
+	if from := vars.Get("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid from date (RFC 3339).")
+			return
+		}
+	}
+	if to := vars.Get("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid to date (RFC 3339).")
+			return
+		}
+	}
+
+	entries, err := types.GetAuditEntries(a.DB, filter, count, start)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, entries)
+}
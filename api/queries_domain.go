@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/outout14/sacrebleu-api/api/types"
 	"gorm.io/gorm"
@@ -12,16 +13,62 @@ import (
 )
 
 //domainVerify: Verify if the domain exist and the user avec access to it
-func domainVerify(err error, w http.ResponseWriter, user types.User, d types.Domain) bool {
+//Reads need the viewer role, the other methods the editor role (see domainManage for the owner role)
+//The role of the user is kept in the request context for recordsVerify
+func (a *Server) domainVerify(err error, w http.ResponseWriter, r *http.Request, d types.Domain) bool {
+	required := types.RoleEditor
+	if r.Method == http.MethodGet {
+		required = types.RoleViewer
+	}
+	return a.domainAccess(err, w, r, d, required)
+}
+
+//domainManage : Verify if the domain exist and the user can manage it (owner role : deletion, owner and team changes)
+func (a *Server) domainManage(err error, w http.ResponseWriter, r *http.Request, d types.Domain) bool {
+	return a.domainAccess(err, w, r, d, types.RoleOwner)
+}
+
+//recordsVerify : Verify if the user can change these records of the domain (NS and SOA need the manager role)
+//Must be used after domainVerify
+func recordsVerify(w http.ResponseWriter, r *http.Request, records ...types.Record) bool {
+	role, _ := context.Get(r, "domainRole").(string)
+	if types.RoleAllows(role, types.RoleManager) {
+		return false
+	}
+
+	for _, record := range records {
+		if types.ProtectedRecord(record) {
+			respondWithError(w, http.StatusForbidden, "No access to the NS and SOA records (manager role needed).")
+			return true
+		}
+	}
+	return false
+}
+
+//domainAccess : Verify if the domain exist and the user has the required role on it (directly or through its team)
+func (a *Server) domainAccess(err error, w http.ResponseWriter, r *http.Request, d types.Domain, required string) bool {
+	user := context.Get(r, "user").(types.User)
+	token := context.Get(r, "token").(types.Token)
+
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Domain not found.")
 		return true
 	}
 
-	if !user.IsOwner(d) {
+	role, err := user.DomainRole(a.DB, d)
+	if checkSrvErr(err, w) {
+		return true
+	}
+	if !types.RoleAllows(role, required) {
 		respondWithError(w, http.StatusForbidden, "No access to this domain (no permission).")
 		return true
 	}
+	context.Set(r, "domainRole", role)
+
+	if !token.AllowsDomain(d.ID, r.Method != http.MethodGet) {
+		respondWithError(w, http.StatusForbidden, "No access to this domain (token scope).")
+		return true
+	}
 	return false
 }
 
@@ -38,8 +85,6 @@ func domainVerify(err error, w http.ResponseWriter, user types.User, d types.Dom
 // @Tags Domains
 // @Router /domain/{domain_id} [get]
 func (a *Server) getDomain(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
-
 	id, dbg := getID(r, w)
 	if dbg {
 		return
@@ -49,7 +94,7 @@ func (a *Server) getDomain(w http.ResponseWriter, r *http.Request) {
 
 	err := d.GetDomain(a.DB)
 
-	if domainVerify(err, w, user, d) {
+	if a.domainVerify(err, w, r, d) {
 		return
 	}
 
@@ -59,7 +104,7 @@ func (a *Server) getDomain(w http.ResponseWriter, r *http.Request) {
 // getDomains endpoint.
 // @Security ApiKeyAuth
 // @Summary Get all domains accessibles by the user
-// @Description List of all domains accessibles (write & edit) according to the user permissions
+// @Description List of all domains accessibles (write & edit) according to the user permissions (owned by the user or its teams)
 // @Accept  json
 // @Produce  json
 // @ID domains
@@ -79,7 +124,13 @@ func (a *Server) getDomains(w http.ResponseWriter, r *http.Request) {
 	count = calcCount(count)
 	start = calcStart(start)
 
-	domains, err := types.GetDomains(a.DB, user, count, start)
+	//Tokens with records:<domain_id> scopes only see their domains
+	db := a.DB
+	if token := context.Get(r, "token").(types.Token); token.Restricted() {
+		db = db.Where("id IN ?", token.RecordsDomains())
+	}
+
+	domains, err := types.GetDomains(db, user, count, start)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
@@ -91,6 +142,7 @@ func (a *Server) getDomains(w http.ResponseWriter, r *http.Request) {
 // @Security ApiKeyAuth
 // @Summary Get domain records
 // @Description Get domain records in the database by the domain ID
+// @Description The users without viewer role on the domain get the records their permission rules match.
 // @ID domainrecord
 // @Accept  json
 // @Produce  json
@@ -102,8 +154,6 @@ func (a *Server) getDomains(w http.ResponseWriter, r *http.Request) {
 // @Tags Domains, Records
 // @Router /domain/{domain_id}/records [get]
 func (a *Server) getDomainRecords(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
-
 	//Parsing request vars
 	vars := r.URL.Query()
 	count, _ := strconv.Atoi(vars.Get("count"))
@@ -119,7 +169,26 @@ func (a *Server) getDomainRecords(w http.ResponseWriter, r *http.Request) {
 	d := types.Domain{ID: domainID}
 	err := d.GetDomain(a.DB)
 
-	if domainVerify(err, w, user, d) {
+	if a.recordReadVerify(err, w, r, d) {
+		return
+	}
+
+	//The subjects of permission rules get the records their rules match, paginated after the filtering
+	role, _ := context.Get(r, "domainRole").(string)
+	if !types.RoleAllows(role, types.RoleViewer) {
+		records, err := d.GetDomainRecords(a.DB, -1, -1)
+		if checkSrvErr(err, w) {
+			return
+		}
+		records = readableRecords(r, records)
+		if start > len(records) {
+			start = len(records)
+		}
+		end := start + count
+		if end > len(records) {
+			end = len(records)
+		}
+		respondWithJSON(w, http.StatusOK, records[start:end])
 		return
 	}
 
@@ -136,6 +205,8 @@ func (a *Server) getDomainRecords(w http.ResponseWriter, r *http.Request) {
 // @Security ApiKeyAuth
 // @Summary Create domain
 // @Description Create a domain in the database
+// @Description Set TeamID to give the domain to a team (editors of the team only).
+// @Description AllowTransfer lists the networks of the secondaries allowed to transfer the zone (AXFR / IXFR) without TSIG key.
 // @ID newdomain
 // @Accept  json
 // @Produce  json
@@ -161,30 +232,51 @@ func (a *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 	if !user.IsAdmin { //Non-admin can't create domains for others
 		submitedDomain.OwnerID = user.ID
 	}
+	if a.teamAssignable(w, user, submitedDomain.TeamID) {
+		return
+	}
 
-	if submitedDomain.Exists(a.DB) {
-		respondWithError(w, http.StatusConflict, "Domain with the same FQDN already exists.")
+	if err := submitedDomain.AllowTransfer.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	err := submitedDomain.CreateDomain(a.DB)
-	if checkSrvErr(err, w) {
+	if submitedDomain.Exists(a.DB) {
+		respondWithError(w, http.StatusConflict, "Domain with the same FQDN already exists.")
 		return
 	}
 
-	//Create NS records
-	nameservers := a.Conf.DNS.Nameservers
+	//The domain, its NS records and its first version are created together
+	err := a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := submitedDomain.CreateDomain(tx); err != nil {
+			return err
+		}
 
-	for _, nsName := range nameservers {
-		nsRecord := types.Record{
-			DomainID: submitedDomain.ID,
-			Fqdn:     submitedDomain.Fqdn,
-			Content:  nsName,
-			Type:     2,
-			TTL:      9600,
+		//Create NS records
+		nameservers := a.Conf.DNS.Nameservers
+		history := []types.RecordChange{}
+
+		for _, nsName := range nameservers {
+			nsRecord := types.Record{
+				DomainID: submitedDomain.ID,
+				Fqdn:     submitedDomain.Fqdn,
+				Content:  nsName,
+				Type:     2,
+				TTL:      9600,
+			}
+			if err := nsRecord.CreateRecord(tx); err != nil {
+				return err
+			}
+			history = append(history, types.RecordChange{After: &nsRecord})
 		}
-		nsRecord.CreateRecord(a.DB)
+
+		after := submitedDomain
+		return submitedDomain.LogChanges(tx, user, "domain.create", history, nil, &after)
+	})
+	if checkSrvErr(err, w) {
+		return
 	}
+	setAuditDomain(r, submitedDomain.ID)
 
 	respondWithJSON(w, http.StatusOK, submitedDomain)
 }
@@ -192,7 +284,8 @@ func (a *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 // updateDomain endpoint.
 // @Security ApiKeyAuth
 // @Summary Update domain
-// @Description Update a existing domain in the database by his ID (not reversible.)
+// @Description Update a existing domain in the database by his ID (logged in the domain history.)
+// @Description Changing OwnerID or TeamID needs the owner role on the domain, changing AllowTransfer or Serial the manager role.
 // @ID putdomain
 // @Accept  json
 // @Produce  json
@@ -216,7 +309,7 @@ func (a *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Domain not found.")
 		return
 	}
-	if domainVerify(err, w, user, d) {
+	if a.domainVerify(err, w, r, d) {
 		return
 	}
 
@@ -233,7 +326,38 @@ func (a *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
 	submitedDomain.ID = d.ID
 	submitedDomain.Fqdn = d.Fqdn
 
-	err = submitedDomain.UpdateDomain(a.DB)
+	//Giving the domain to another user or team needs the owner role
+	if submitedDomain.OwnerID != d.OwnerID || submitedDomain.TeamID != d.TeamID {
+		if a.domainManage(nil, w, r, d) {
+			return
+		}
+		if submitedDomain.TeamID != d.TeamID && a.teamAssignable(w, user, submitedDomain.TeamID) {
+			return
+		}
+	}
+
+	//The serial is written in the SOA : changing it needs the manager role
+	if submitedDomain.Serial != d.Serial && a.domainAccess(nil, w, r, d, types.RoleManager) {
+		return
+	}
+
+	//Opening the zone transfers needs the manager role
+	if strings.Join(submitedDomain.AllowTransfer, " ") != strings.Join(d.AllowTransfer, " ") {
+		if a.domainAccess(nil, w, r, d, types.RoleManager) {
+			return
+		}
+		if err := submitedDomain.AllowTransfer.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := submitedDomain.UpdateDomain(tx); err != nil {
+			return err
+		}
+		return submitedDomain.LogChanges(tx, user, "domain.update", nil, &d, &submitedDomain)
+	})
 	if checkSrvErr(err, w) {
 		return
 	}
@@ -244,7 +368,8 @@ func (a *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
 // deleteDomain endpoint.
 // @Security ApiKeyAuth
 // @Summary Delete domain
-// @Description Delete a domain in the database by his ID (not reversible.)
+// @Description Delete a domain in the database by his ID (can be restored with the domain rollback.)
+// @Description Needs the owner role on the domain (team owners for the team domains).
 // @ID deldomain
 // @Produce  json
 // @Param   domain_id      path   int     true  "1"
@@ -264,19 +389,46 @@ func (a *Server) deleteDomain(w http.ResponseWriter, r *http.Request) {
 
 	err := d.GetDomain(a.DB)
 
-	if domainVerify(err, w, user, d) {
+	if a.domainManage(err, w, r, d) {
 		return
 	}
 
-	//Delete all domain records
-	err = d.DeleteAllDomainRecords(a.DB)
-	if err != nil && err != gorm.ErrRecordNotFound {
-		respondWithError(w, http.StatusInternalServerError, "Server error.")
+	//Keep the records in the domain history to be able to restore it
+	records, err := d.GetDomainRecords(a.DB, -1, -1)
+	if checkSrvErr(err, w) {
 		return
 	}
+	history := make([]types.RecordChange, len(records))
+	for i := range records {
+		history[i] = types.RecordChange{Before: &records[i]}
+	}
+	before := d
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		//Delete all domain records
+		err := d.DeleteAllDomainRecords(tx)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		//Stop sharing the domain
+		if err := d.DeleteAllMembers(tx); err != nil {
+			return err
+		}
+		if err := d.DeleteDomainRules(tx); err != nil {
+			return err
+		}
+		if err := d.DeleteTSIGKeyDomains(tx); err != nil {
+			return err
+		}
+
+		//Delete the domain item itself
+		if err := d.DeleteDomain(tx); err != nil {
+			return err
+		}
 
-	//Delete the domain item itself
-	err = d.DeleteDomain(a.DB)
+		return d.LogChanges(tx, user, "domain.delete", history, &before, nil)
+	})
 	if checkSrvErr(err, w) {
 		return
 	}
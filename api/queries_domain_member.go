@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+// getDomainMembers endpoint.
+// @Security ApiKeyAuth
+// @Summary Get domain members
+// @Description List the users the domain is shared with and their role
+// @ID domainmembers
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Success 200 {object} []types.DomainMember
+// @Failure 400,403,404 {object} Response
+// @Tags Domains
+// @Router /domain/{domain_id}/members [get]
+func (a *Server) getDomainMembers(w http.ResponseWriter, r *http.Request) {
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	d := types.Domain{ID: id}
+	err := d.GetDomain(a.DB)
+	if a.domainVerify(err, w, r, d) {
+		return
+	}
+
+	members, err := d.GetMembers(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// setDomainMember endpoint.
+// @Security ApiKeyAuth
+// @Summary Share domain
+// @Description Share the domain with a user or change its role (domain managers only).
+// @Description Roles : viewer (read), editor (write the records except NS and SOA) or manager (also NS, SOA and sharing).
+// @ID newdomainmember
+// @Accept  json
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Domains
+// @Router /domain/{domain_id}/members [post]
+func (a *Server) setDomainMember(w http.ResponseWriter, r *http.Request) {
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	d := types.Domain{ID: id}
+	err := d.GetDomain(a.DB)
+	if a.domainAccess(err, w, r, d, types.RoleManager) {
+		return
+	}
+
+	var payload MemberPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return
+	}
+	defer r.Body.Close()
+
+	if !types.ValidDomainRole(payload.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role (viewer, editor or manager).")
+		return
+	}
+
+	u := types.User{ID: payload.UserID}
+	err = u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "User not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	err = d.SetMember(a.DB, u.ID, payload.Role)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// deleteDomainMember endpoint.
+// @Security ApiKeyAuth
+// @Summary Stop sharing domain
+// @Description Stop sharing the domain with a user (domain managers, or the user leaving it)
+// @ID deldomainmember
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   user_id      path   int     true  "2"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Domains
+// @Router /domain/{domain_id}/members/{user_id} [delete]
+func (a *Server) deleteDomainMember(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+	memberID, dbg := getVarID(r, w, "user_id")
+	if dbg {
+		return
+	}
+
+	//Every member can leave the domain
+	required := types.RoleManager
+	if memberID == user.ID {
+		required = types.RoleViewer
+	}
+
+	d := types.Domain{ID: id}
+	err := d.GetDomain(a.DB)
+	if a.domainAccess(err, w, r, d, required) {
+		return
+	}
+
+	err = d.RemoveMember(a.DB, memberID)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "Domain member not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
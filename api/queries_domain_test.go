@@ -0,0 +1,36 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+//The editors can't change the serial of the SOA
+func TestUpdateDomainSerial(t *testing.T) {
+	a, admin := newTestServer(t)
+	dave, token := newTestUser(t, a, "dave")
+
+	if status, body := a.request(admin, "POST", "/api/domain", `{"Fqdn":"example.org."}`); status != http.StatusOK {
+		t.Fatalf("can't create the domain : %d %s", status, body)
+	}
+
+	tests := []struct {
+		role   string
+		body   string
+		status int
+	}{
+		{"editor", `{"Description":"test"}`, http.StatusNoContent},
+		{"editor", `{"Serial":2099010100}`, http.StatusForbidden},
+		{"manager", `{"Serial":2099010100}`, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		member := fmt.Sprintf(`{"UserID":%v,"Role":"%s"}`, dave.ID, test.role)
+		if status, body := a.request(admin, "POST", "/api/domain/1/members", member); status != http.StatusOK && status != http.StatusNoContent {
+			t.Fatalf("can't share the domain : %d %s", status, body)
+		}
+		if status, body := a.request(token, "PUT", "/api/domain/1", test.body); status != test.status {
+			t.Errorf("%s %s : got %d %s, want %d", test.role, test.body, status, body, test.status)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/miekg/dns"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+//DynDNS2 protocol answers (https://help.dyn.com/remote-access-api/return-codes/)
+const (
+	dynDNSGood    = "good"
+	dynDNSNoChg   = "nochg"
+	dynDNSNoHost  = "nohost"
+	dynDNSBadAuth = "badauth"
+	dynDNSNotFQDN = "notfqdn"
+	dynDNSNumHost = "numhost"
+	dynDNSError   = "911"
+)
+
+//dynDNSMaxHosts : maximum number of hostnames updated by a request
+const dynDNSMaxHosts = 20
+
+//errDynDNSAuth : wrong DynDNS credentials
+var errDynDNSAuth = errors.New("invalid credentials")
+
+//dynDNSAuth : authenticate the HTTP Basic credentials (username and one of its API tokens as password)
+func (a *Server) dynDNSAuth(username string, password string) (types.User, types.Token, error) {
+	token := types.Token{Secret: password}
+	if err := token.GetTokenBySecret(a.DB); err != nil || token.Expired() {
+		return types.User{}, token, errDynDNSAuth
+	}
+
+	user := types.User{ID: token.UserID}
+	if err := user.GetUser(a.DB); err != nil || !strings.EqualFold(user.Username, username) {
+		return user, token, errDynDNSAuth
+	}
+
+	if !token.HasScope(types.ScopeAdmin) {
+		user.IsAdmin = false
+	}
+	token.Touch(a.DB)
+	return user, token, nil
+}
+
+//dynDNSAddresses : IPv4 and IPv6 addresses of the request (myip and myipv6 parameters, the client address if there is no valid one)
+func (a *Server) dynDNSAddresses(r *http.Request) []net.IP {
+	vars := r.URL.Query()
+
+	var v4, v6 net.IP
+	for _, value := range strings.Split(vars.Get("myip")+","+vars.Get("myipv6"), ",") {
+		ip := net.ParseIP(strings.TrimSpace(value))
+		switch {
+		case ip == nil:
+		case ip.To4() != nil && v4 == nil:
+			v4 = ip.To4()
+		case ip.To4() == nil && v6 == nil:
+			v6 = ip
+		}
+	}
+
+	if v4 == nil && v6 == nil { //Invalid addresses are ignored (as dyn.com does)
+		if ip := net.ParseIP(a.sourceIP(r)); ip != nil && ip.To4() != nil {
+			v4 = ip.To4()
+		} else if ip != nil {
+			v6 = ip
+		}
+	}
+
+	ips := []net.IP{}
+	for _, ip := range []net.IP{v4, v6} {
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+//dynDNSHost : update the A / AAAA records of a hostname and get the protocol answer
+func (a *Server) dynDNSHost(r *http.Request, user types.User, token types.Token, hostname string, ips []net.IP) string {
+	fqdn := dns.Fqdn(strings.ToLower(strings.TrimSpace(hostname)))
+	if _, ok := dns.IsDomainName(fqdn); !ok || dns.CountLabel(fqdn) < 2 {
+		return dynDNSNotFQDN
+	}
+
+	d, err := types.GetDomainOf(a.DB, fqdn)
+	if err == gorm.ErrRecordNotFound || (err == nil && !token.AllowsDomain(d.ID, true)) {
+		return dynDNSNoHost
+	}
+	if err != nil {
+		return dynDNSError
+	}
+	setAuditDomain(r, d.ID)
+
+	//Editors can change every address, the others need permission rules
+	role, err := user.DomainRole(a.DB, d)
+	if err != nil {
+		return dynDNSError
+	}
+	var rules []types.RecordRule
+	if !types.RoleAllows(role, types.RoleEditor) {
+		if rules, err = types.GetSubjectRules(a.DB, d.ID, user.ID, token.ID); err != nil {
+			return dynDNSError
+		}
+		if len(rules) == 0 {
+			return dynDNSNoHost
+		}
+	}
+
+	//The hostname must already have an address
+	var existing []types.Record
+	if err := a.DB.Where("domain_id = ? AND fqdn = ? AND type IN ?", d.ID, fqdn, []int{1, 28}).Order("id").Find(&existing).Error; err != nil {
+		return dynDNSError
+	}
+	if len(existing) == 0 {
+		return dynDNSNoHost
+	}
+
+	changes := types.ZoneChanges{Created: []types.Record{}, Updated: []types.Record{}, Deleted: []types.Record{}}
+	allowed := true
+	for _, ip := range ips {
+		rrtype := types.RRType(1)
+		if ip.To4() == nil {
+			rrtype = 28
+		}
+
+		var current []types.Record
+		for _, record := range existing {
+			if record.Type == rrtype {
+				current = append(current, record)
+			}
+		}
+
+		switch {
+		case len(current) == 1 && ip.Equal(net.ParseIP(current[0].Content)):
+			continue
+		case len(current) == 0:
+			record := types.Record{DomainID: d.ID, Fqdn: fqdn, Type: rrtype, TTL: existing[0].TTL, Content: ip.String()}
+			changes.Created = append(changes.Created, record)
+			allowed = allowed && (rules == nil || types.RulesAllow(rules, types.ActionCreate, record))
+		default: //The first record gets the address, the others are deleted
+			record := current[0]
+			record.Content = ip.String()
+			changes.Updated = append(changes.Updated, record)
+			changes.Deleted = append(changes.Deleted, current[1:]...)
+			allowed = allowed && (rules == nil || types.RulesAllow(rules, types.ActionUpdate, record) && types.RulesAllow(rules, types.ActionUpdate, current[0]))
+			for _, deleted := range current[1:] {
+				allowed = allowed && (rules == nil || types.RulesAllow(rules, types.ActionDelete, deleted))
+			}
+		}
+	}
+	if !allowed {
+		return dynDNSNoHost
+	}
+
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = ip.String()
+	}
+	if changes.Empty() {
+		return fmt.Sprintf("%s %s", dynDNSNoChg, strings.Join(addresses, ","))
+	}
+
+	if err := d.ApplyChanges(a.DB, user, "dyndns.update", changes); err != nil {
+		logrus.WithFields(logrus.Fields{"hostname": fqdn, "error": err}).Error("DYNDNS : Can't update the records")
+		return dynDNSError
+	}
+	return fmt.Sprintf("%s %s", dynDNSGood, strings.Join(addresses, ","))
+}
+
+//dynDNSUpdate : DynDNS2 protocol endpoint (/nic/update?hostname=<fqdn>[,<fqdn>]&myip=<ip>[,<ipv6>]), for the routers and ddclient
+//HTTP Basic authentication : the username and one of its API tokens as password
+func (a *Server) dynDNSUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sacrebleu"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, dynDNSBadAuth)
+		return
+	}
+	user, token, err := a.dynDNSAuth(username, password)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"username": username, "ip": a.sourceIP(r)}).Info("DYNDNS : Authentication failed")
+		fmt.Fprintln(w, dynDNSBadAuth)
+		return
+	}
+
+	//Will be used by the audit log
+	context.Set(r, "user", user)
+	context.Set(r, "token", token)
+
+	hostnames := strings.Split(r.URL.Query().Get("hostname"), ",")
+	if len(hostnames) > dynDNSMaxHosts {
+		fmt.Fprintln(w, dynDNSNumHost)
+		return
+	}
+
+	ips := a.dynDNSAddresses(r)
+	for _, hostname := range hostnames {
+		fmt.Fprintln(w, a.dynDNSHost(r, user, token, hostname, ips))
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//historyDomain : get the domain, or its state before deletion if it has been deleted
+//The deletion changeset is returned for deleted domains (nil otherwise)
+func (a *Server) historyDomain(w http.ResponseWriter, r *http.Request, id int) (types.Domain, *types.Changeset, bool) {
+	d := types.Domain{ID: id}
+	err := d.GetDomain(a.DB)
+
+	var deletion *types.Changeset
+	if err == gorm.ErrRecordNotFound {
+		c, derr := d.GetDeletion(a.DB)
+		if derr == nil && c.DomainBefore != nil {
+			d = *c.DomainBefore
+			deletion = &c
+			err = nil
+		}
+	}
+
+	if a.domainVerify(err, w, r, d) {
+		return d, nil, true
+	}
+	return d, deletion, false
+}
+
+// getDomainHistory endpoint.
+// @Security ApiKeyAuth
+// @Summary Get domain history
+// @Description Get the versioned changes made to the domain and its records (newest first), also available for deleted domains
+// @ID domainhistory
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   count      query   int     false  "10"
+// @Param   start      query   int     false  "1"
+// @Success 200 {object} []types.Changeset
+// @Failure 400,403,404 {object} Response
+// @Tags Domains
+// @Router /domain/{domain_id}/history [get]
+func (a *Server) getDomainHistory(w http.ResponseWriter, r *http.Request) {
+	//Parsing request vars
+	vars := r.URL.Query()
+	count, _ := strconv.Atoi(vars.Get("count"))
+	start, _ := strconv.Atoi(vars.Get("start"))
+	count = calcCount(count)
+	start = calcStart(start)
+
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	d, _, dbg := a.historyDomain(w, r, domainID)
+	if dbg {
+		return
+	}
+
+	history, err := d.GetHistory(a.DB, count, start)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, history)
+}
+
+// rollbackDomain endpoint.
+// @Security ApiKeyAuth
+// @Summary Rollback domain
+// @Description Restore the domain records as they were at the given version of its history (the SOA is regenerated).
+// @Description A deleted domain is restored if the version is older than its deletion. The rollback is itself logged as a new version.
+// @ID rollbackdomain
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   version      query   int     true  "3"
+// @Success 200 {object} types.ZoneChanges
+// @Failure 400,403,404,409 {object} Response
+// @Tags Domains
+// @Router /domain/{domain_id}/rollback [post]
+func (a *Server) rollbackDomain(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		respondWithError(w, http.StatusBadRequest, "Invalid version.")
+		return
+	}
+
+	d, deletion, dbg := a.historyDomain(w, r, domainID)
+	if dbg {
+		return
+	}
+
+	last, err := d.GetHistory(a.DB, 1, 0)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if len(last) == 0 || version > last[0].Version {
+		respondWithError(w, http.StatusNotFound, "Version not found.")
+		return
+	}
+
+	if deletion != nil {
+		if version >= deletion.Version {
+			respondWithError(w, http.StatusBadRequest, "The domain is deleted, the version must be older than its deletion.")
+			return
+		}
+		if a.domainManage(nil, w, r, d) { //Restoring needs the same role as the deletion
+			return
+		}
+		taken, err := d.FqdnTaken(a.DB) //Exists would look for the deleted ID only
+		if checkSrvErr(err, w) {
+			return
+		}
+		if taken {
+			respondWithError(w, http.StatusConflict, "Domain with the same FQDN already exists.")
+			return
+		}
+		d = *deletion.DomainBefore
+	}
+
+	changes, err := d.RollbackChanges(a.DB, version)
+	if checkSrvErr(err, w) {
+		return
+	}
+	existing, err := d.GetDomainRecords(a.DB, -1, -1)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if recordsVerify(w, r, changes.Touched(existing)...) {
+		return
+	}
+	if deletion == nil && changes.Empty() {
+		respondWithJSON(w, http.StatusOK, changes)
+		return
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if deletion != nil { //Restore the domain itself with its ID
+			if err := d.CreateDomain(tx); err != nil {
+				return err
+			}
+			after := d
+			if err := d.LogChanges(tx, user, "domain.restore", nil, nil, &after); err != nil {
+				return err
+			}
+		}
+		return d.ApplyChanges(tx, user, "domain.rollback", changes)
+	})
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, changes)
+}
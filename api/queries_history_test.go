@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+//A deleted domain can't be restored once another domain has its FQDN
+func TestRollbackDeletedDomainFqdnTaken(t *testing.T) {
+	a, token := newTestServer(t)
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"POST", "/api/domain", `{"Fqdn":"example.org."}`, http.StatusOK},
+		{"POST", "/api/domain", `{"Fqdn":"example.net."}`, http.StatusOK}, //The IDs of sqlite are reused after the last one is deleted
+		{"DELETE", "/api/domain/1", ``, http.StatusNoContent},
+		{"POST", "/api/domain", `{"Fqdn":"example.org."}`, http.StatusOK},
+		{"POST", "/api/domain/1/rollback?version=1", ``, http.StatusConflict},
+		{"DELETE", "/api/domain/3", ``, http.StatusNoContent},
+		{"POST", "/api/domain/1/rollback?version=1", ``, http.StatusOK},
+	}
+	for _, step := range steps {
+		if status, body := a.request(token, step.method, step.path, step.body); status != step.status {
+			t.Fatalf("%s %s : got %d %s, want %d", step.method, step.path, status, body, step.status)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	oidcLoginLifetime = 10 * time.Minute
+	oidcTOTPLifetime  = 5 * time.Minute
+)
+
+//OIDCLink : IdP login page to link the account to the single sign-on
+type OIDCLink struct {
+	URL string `example:"https://idp.example.com/authorize?client_id=sacrebleu&state=..."`
+}
+
+//OIDCSecondFactor : Ticket of a single sign-on login waiting for the TOTP code (POST /oidc/totp)
+type OIDCSecondFactor struct {
+	Ticket    string `example:"mY7X2Jt1b0eR4oQ9pH5lZc8nV3aK6wFs-dGuIyTqE_0"`
+	ExpiresAt time.Time
+}
+
+// oidcLogin endpoint.
+// @Summary Single sign-on login
+// @Description Redirect to the login page of the OpenID Connect IdP
+// @ID oidclogin
+// @Success 302
+// @Failure 404,502 {object} Response
+// @Tags Users
+// @Router /oidc/login [get]
+func (a *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+	if err := a.oidc.init(r.Context()); err != nil {
+		logrus.Errorf("OIDC : Can't reach the IdP : %s", err)
+		respondWithError(w, http.StatusBadGateway, "Can't reach the identity provider.")
+		return
+	}
+
+	_, authURL := a.startOIDCLogin(w)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+//startOIDCLogin : set the cookie of the login and get its state and the URL of the IdP login page
+func (a *Server) startOIDCLogin(w http.ResponseWriter) (string, string) {
+	//Kept by the browser until the callback
+	state, nonce, verifier := GenerateToken(), GenerateToken(), GenerateToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.oidc.conf.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state, a.oidc.authURL(state, nonce, verifier)
+}
+
+// linkOIDC endpoint.
+// @Security ApiKeyAuth
+// @Summary Link account to single sign-on
+// @Description Get the login page of the OpenID Connect IdP to link the logged account to its IdP account (the login cookie is set).
+// @Description Once linked, the account logs in with the single sign-on only. Requires a session (not an API token).
+// @ID oidclink
+// @Produce json
+// @Success 200 {object} OIDCLink
+// @Failure 400,403,404,502 {object} Response
+// @Tags Users
+// @Router /user/self/oidc [post]
+func (a *Server) linkOIDC(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if a.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+	if _, ok := context.Get(r, "session").(int); !ok {
+		respondWithError(w, http.StatusBadRequest, "Not a session token (log in to link the account).")
+		return
+	}
+	if err := a.oidc.init(r.Context()); err != nil {
+		logrus.Errorf("OIDC : Can't reach the IdP : %s", err)
+		respondWithError(w, http.StatusBadGateway, "Can't reach the identity provider.")
+		return
+	}
+
+	state, authURL := a.startOIDCLogin(w)
+	a.oidc.links.add(state, user.ID, oidcLoginLifetime)
+
+	respondWithJSON(w, http.StatusOK, OIDCLink{URL: authURL})
+}
+
+// oidcCallback endpoint.
+// @Summary Single sign-on callback
+// @Description Redirection of the IdP after the login. The user is created at its first login, existing accounts are linked by their owners (POST /user/self/oidc).
+// @Description The session is returned as JSON, or in the fragment of the dashboard URL (PostLoginURL) : #token=...&refresh_token=...&expires_at=...
+// @Description Users with TOTP get a ticket instead (202, or #totp_ticket=...&expires_at=...) to open the session with POST /oidc/totp.
+// @ID oidccallback
+// @Produce json
+// @Param   code      query   string     true  "Authorization code"
+// @Param   state      query   string     true  "State"
+// @Success 200 {object} UserSession
+// @Success 202 {object} OIDCSecondFactor
+// @Success 302
+// @Failure 400,403,404,409 {object} Response
+// @Tags Users
+// @Router /oidc/callback [get]
+func (a *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+	if err := a.oidc.init(r.Context()); err != nil {
+		logrus.Errorf("OIDC : Can't reach the IdP : %s", err)
+		respondWithError(w, http.StatusBadGateway, "Can't reach the identity provider.")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/api/oidc", MaxAge: -1}) //Single use
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired, try again.")
+		return
+	}
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 || values[0] != r.URL.Query().Get("state") {
+		respondWithError(w, http.StatusBadRequest, "Invalid login state, try again.")
+		return
+	}
+	if idpErr := r.URL.Query().Get("error"); idpErr != "" {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Login refused by the identity provider (%s).", idpErr))
+		return
+	}
+
+	claims, err := a.oidc.exchange(r.Context(), r.URL.Query().Get("code"), values[1], values[2])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ip": a.sourceIP(r)}).Warningf("OIDC : Login failed : %s", err)
+		respondWithError(w, http.StatusForbidden, "Login failed.")
+		return
+	}
+
+	//Link of an account asked from its session
+	if userID, ok := a.oidc.links.take(values[0]); ok {
+		user, err := a.oidc.link(a.DB, userID, claims)
+		if err == errOIDCLinked {
+			respondWithError(w, http.StatusConflict, "This identity is already linked to another account.")
+			return
+		}
+		if checkSrvErr(err, w) {
+			return
+		}
+		logrus.WithFields(logrus.Fields{"user": user.ID, "ip": a.sourceIP(r)}).Info("OIDC : Account linked")
+
+		if a.oidc.conf.PostLoginURL == "" {
+			respondWithJSON(w, http.StatusOK, newUserInfo(user))
+			return
+		}
+		http.Redirect(w, r, a.oidc.conf.PostLoginURL+"#linked=true", http.StatusFound)
+		return
+	}
+
+	user, err := a.oidc.user(a.DB, claims)
+	if err == errOIDCUsername {
+		respondWithError(w, http.StatusConflict, "The username or the email is already used by another account (log in to link it to the single sign-on).")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	//Second factor : the session is opened by POST /oidc/totp
+	if user.TOTPEnabled {
+		pending := OIDCSecondFactor{Ticket: GenerateToken(), ExpiresAt: time.Now().Add(oidcTOTPLifetime)}
+		a.oidc.tickets.add(pending.Ticket, user.ID, oidcTOTPLifetime)
+
+		if a.oidc.conf.PostLoginURL == "" {
+			respondWithJSON(w, http.StatusAccepted, pending)
+			return
+		}
+		fragment := url.Values{}
+		fragment.Set("totp_ticket", pending.Ticket)
+		fragment.Set("expires_at", pending.ExpiresAt.Format(time.RFC3339))
+		http.Redirect(w, r, a.oidc.conf.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+		return
+	}
+
+	a.respondOIDCSession(w, r, user)
+}
+
+// oidcTOTP endpoint.
+// @Summary Single sign-on second factor
+// @Description Open the session of a single sign-on login with the TOTP (or recovery) code of the user. The ticket is single use.
+// @Description Too many failed codes from an IP or for an account lock them for a while (429).
+// @ID oidctotp
+// @Produce json
+// @Param   ticket      formData   string     true  "Ticket of the callback"
+// @Param   otp      formData   string     true  "TOTP or recovery code"
+// @Success 200 {object} UserSession
+// @Failure 403,404,429 {object} Response
+// @Tags Users, TOTP
+// @Router /oidc/totp [post]
+func (a *Server) oidcTOTP(w http.ResponseWriter, r *http.Request) {
+	if a.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "Single sign-on is not configured.")
+		return
+	}
+
+	userID, ok := a.oidc.tickets.take(r.FormValue("ticket"))
+	if !ok {
+		respondWithError(w, http.StatusForbidden, "Login expired, try again.")
+		return
+	}
+	user := types.User{ID: userID}
+	err := user.GetUser(a.DB)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, "Login expired, try again.")
+		return
+	}
+
+	//Brute-force protection (shared with the password logins)
+	lockedUntil, err := a.throttle.lockedUntil(a.sourceIP(r), user.Username)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if !lockedUntil.IsZero() {
+		respondLocked(w, lockedUntil, "Too many failed logins, try again later.")
+		return
+	}
+	if !user.CheckSecondFactor(a.DB, r.FormValue("otp")) {
+		a.loginFailed(w, r, user.Username, user.ID)
+		return
+	}
+	err = a.throttle.reset(user.Username)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	a.respondOIDCSession(w, r, user)
+}
+
+//respondOIDCSession : open the session of the user, returned as JSON or in the fragment of the dashboard URL
+func (a *Server) respondOIDCSession(w http.ResponseWriter, r *http.Request, user types.User) {
+	session, err := a.startSession(user)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	if a.oidc.conf.PostLoginURL == "" {
+		respondWithJSON(w, http.StatusOK, session)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", session.Token)
+	fragment.Set("refresh_token", session.RefreshToken)
+	fragment.Set("expires_at", session.ExpiresAt.Format(time.RFC3339))
+	http.Redirect(w, r, a.oidc.conf.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	passwordResetLifetime = time.Hour
+	emailVerifyLifetime   = 48 * time.Hour
+)
+
+//sendActionToken : create a single-use token for the action and send it by mail to the user
+func (a *Server) sendActionToken(u types.User, action string, lifetime time.Duration) error {
+	secret := GenerateToken()
+	token := types.ActionToken{UserID: u.ID, Action: action, Email: u.Email, ExpiresAt: time.Now().Add(lifetime)}
+	if err := token.CreateActionToken(a.DB, secret); err != nil {
+		return err
+	}
+
+	var subject, body string
+	switch action {
+	case types.ActionPasswordReset:
+		link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimSuffix(a.Config.Mail.BaseURL, "/"), url.QueryEscape(secret))
+		subject = "Sacrebleu : password reset"
+		body = fmt.Sprintf("Hello %s,\r\n\r\nA password reset was asked for your account. Use this link to choose a new password (valid %v) :\r\n%s\r\n\r\nIgnore this mail if you didn't ask for it.\r\n", u.Username, lifetime, link)
+	case types.ActionEmailVerify:
+		link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimSuffix(a.Config.Mail.BaseURL, "/"), url.QueryEscape(secret))
+		subject = "Sacrebleu : email verification"
+		body = fmt.Sprintf("Hello %s,\r\n\r\nUse this link to verify your email address (valid %v) :\r\n%s\r\n", u.Username, lifetime, link)
+	}
+	return a.mailer.Send(u.Email, subject, body)
+}
+
+// forgotPassword endpoint.
+// @Summary Forgot password
+// @Description Send a password reset link to the email of the user. The reply is the same if no user has this email. The requests are limited by IP and by email.
+// @ID forgotpassword
+// @Produce json
+// @Param   email      formData   string     true  "admin@example.org"
+// @Success 204
+// @Failure 400,429 {object} Response
+// @Tags Users
+// @Router /password/forgot [post]
+func (a *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing email.")
+		return
+	}
+
+	//Every request is counted : the mails can't be sent faster than the login attempts
+	lockedUntil, err := a.resets.lockedUntil(a.sourceIP(r), email)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if !lockedUntil.IsZero() {
+		respondLocked(w, lockedUntil, "Too many password reset requests, try again later.")
+		return
+	}
+	if err := a.resets.fail(a.sourceIP(r), email); err != nil {
+		logrus.Errorf("AUTH : Can't count the password reset request : %s", err)
+	}
+
+	u := types.User{Email: email}
+	if u.EmailExists(a.DB) && u.Provider == types.ProviderLocal { //Load the user (the others have no password)
+		go func() { //Sent in background : the reply time doesn't tell if the user exists
+			if err := a.sendActionToken(u, types.ActionPasswordReset, passwordResetLifetime); err != nil {
+				logrus.WithFields(logrus.Fields{"user": u.ID}).Errorf("MAIL : Can't send the password reset : %s", err)
+			}
+		}()
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// resetPassword endpoint.
+// @Summary Reset password
+// @Description Set a new password with the token of the password reset mail (single-use). The sessions and the API tokens of the user are revoked.
+// @ID resetpassword
+// @Produce json
+// @Param   token      formData   string     true  "mY7X2Jt1b0eR4oQ9pH5lZc8nV3aK6wFs-dGuIyTqE_0"
+// @Param   password      formData   string     true  "newPassword"
+// @Success 204
+// @Failure 400,403 {object} Response
+// @Tags Users
+// @Router /password/reset [post]
+func (a *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	password := r.FormValue("password")
+	if password == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing password.")
+		return
+	}
+
+	token, err := types.UseActionToken(a.DB, types.ActionPasswordReset, r.FormValue("token"))
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusForbidden, "Token invalid or expired.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	u := types.User{ID: token.UserID}
+	err = u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound || u.Email != token.Email || u.Provider != types.ProviderLocal {
+		respondWithError(w, http.StatusForbidden, "Token invalid or expired.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	u.Password, err = HashPassword(password)
+	if checkSrvErr(err, w) {
+		return
+	}
+	u.EmailVerified = true //The user received the mail
+
+	//Log out everyone using the account (the password may have been stolen)
+	var sessions []types.Session
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := u.UpdateUser(tx); err != nil {
+			return err
+		}
+		if sessions, err = u.RevokeSessions(tx); err != nil {
+			return err
+		}
+		return u.DeleteTokens(tx)
+	})
+	if checkSrvErr(err, w) {
+		return
+	}
+	a.revokeSessions(sessions)
+
+	//The account may have been locked by the failed logins
+	if err := a.throttle.reset(u.Username); err != nil {
+		logrus.WithFields(logrus.Fields{"user": u.ID}).Errorf("AUTH : Can't unlock the user : %s", err)
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// sendEmailVerification endpoint.
+// @Security ApiKeyAuth
+// @Summary Send email verification
+// @Description Send a verification link to the email of the logged user
+// @ID sendemailverification
+// @Produce json
+// @Success 204
+// @Failure 403,409 {object} Response
+// @Tags Users
+// @Router /user/self/email/verify [post]
+func (a *Server) sendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.selfUser(w, r)
+	if dbg {
+		return
+	}
+	if u.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email already verified.")
+		return
+	}
+
+	err := a.sendActionToken(u, types.ActionEmailVerify, emailVerifyLifetime)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// verifyEmail endpoint.
+// @Summary Verify email
+// @Description Confirm the email of the user with the token of the verification mail (single-use)
+// @ID verifyemail
+// @Produce json
+// @Param   token      formData   string     true  "mY7X2Jt1b0eR4oQ9pH5lZc8nV3aK6wFs-dGuIyTqE_0"
+// @Success 204
+// @Failure 403 {object} Response
+// @Tags Users
+// @Router /email/verify [post]
+func (a *Server) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	token, err := types.UseActionToken(a.DB, types.ActionEmailVerify, r.FormValue("token"))
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusForbidden, "Token invalid or expired.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	u := types.User{ID: token.UserID}
+	err = u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound || u.Email != token.Email { //The email changed since the mail
+		respondWithError(w, http.StatusForbidden, "Token invalid or expired.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	u.EmailVerified = true
+	err = u.UpdateUser(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -10,10 +12,57 @@ import (
 	"gorm.io/gorm"
 )
 
+//decodeRecordPayload : parse the submited record(s) from the request body
+//Unknown record types are refused with a field-level explanation
+func decodeRecordPayload(w http.ResponseWriter, r *http.Request, payload interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	err := decoder.Decode(payload)
+	var typeErr *types.UnknownTypeError
+	if errors.As(err, &typeErr) {
+		respondWithValidationErrors(w, "Invalid record.", []types.ValidationError{{Field: "Type", Message: typeErr.Error()}})
+		return true
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return true
+	}
+	return false
+}
+
+//recordConflicts : check if the record can coexist with the other records of the domain (CNAME, duplicates, TTL)
+func (a *Server) recordConflicts(w http.ResponseWriter, d types.Domain, record types.Record) bool {
+	records, err := d.GetDomainRecords(a.DB, -1, -1)
+	if checkSrvErr(err, w) {
+		return true
+	}
+
+	if conflict := types.CheckConflicts(d.Fqdn, records, record); conflict != nil {
+		respondWithConflict(w, conflict)
+		return true
+	}
+	return false
+}
+
+// getRecordTypes endpoint.
+// @Security ApiKeyAuth
+// @Summary Get record types
+// @Description Get the record types mnemonics (IANA registry) and their numeric value
+// @ID recordtypes
+// @Produce  json
+// @Success 200 {object} map[string]int
+// @Tags Records
+// @Router /record/types [get]
+func (a *Server) getRecordTypes(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, types.RRTypes())
+}
+
 // getRecord endpoint.
 // @Security ApiKeyAuth
 // @Summary Get record informations
 // @Description Get a record in the database by his ID
+// @Description The users without viewer role on the domain can get the records their permission rules match.
 // @ID record
 // @Accept  json
 // @Produce  json
@@ -23,8 +72,6 @@ import (
 // @Tags Records
 // @Router /record/{record_id} [get]
 func (a *Server) getRecord(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
-
 	id, dbg := getID(r, w)
 	if dbg {
 		return
@@ -39,8 +86,13 @@ func (a *Server) getRecord(w http.ResponseWriter, r *http.Request) {
 
 	//Check parent domain permissions
 	parentDomain := types.Domain{ID: record.DomainID}
+	setAuditDomain(r, parentDomain.ID)
 	err = parentDomain.GetOwner(a.DB)
-	if domainVerify(err, w, user, parentDomain) {
+	if a.recordReadVerify(err, w, r, parentDomain) {
+		return
+	}
+	if len(readableRecords(r, []types.Record{record})) == 0 {
+		respondWithError(w, http.StatusForbidden, "No access to this record (permission rules).")
 		return
 	}
 
@@ -55,7 +107,9 @@ func (a *Server) getRecord(w http.ResponseWriter, r *http.Request) {
 // @Accept  json
 // @Produce  json
 // @Success 204 {object} types.Record
-// @Failure 400,403,404,409 {object} Response
+// @Failure 400,403,404 {object} Response
+// @Failure 409 {object} ConflictResponse
+// @Failure 422 {object} ValidationResponse
 // @Tags Records
 // @Router /record [post]
 func (a *Server) createRecord(w http.ResponseWriter, r *http.Request) {
@@ -63,17 +117,19 @@ func (a *Server) createRecord(w http.ResponseWriter, r *http.Request) {
 
 	//Parse the submited record
 	var submitedRecord types.Record
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&submitedRecord); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+	if decodeRecordPayload(w, r, &submitedRecord) {
 		return
 	}
-	defer r.Body.Close()
 
 	//Check parent domain permissions
 	parentDomain := types.Domain{ID: submitedRecord.DomainID}
+	setAuditDomain(r, parentDomain.ID)
 	err := parentDomain.GetDomain(a.DB)
-	if domainVerify(err, w, user, parentDomain) {
+	if a.recordVerify(err, w, r, parentDomain) {
+		return
+	}
+
+	if recordsVerify(w, r, submitedRecord) || rulesVerify(w, r, types.ActionCreate, submitedRecord) {
 		return
 	}
 
@@ -87,26 +143,36 @@ func (a *Server) createRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = submitedRecord.CreateRecord(a.DB)
-	if checkSrvErr(err, w) {
+	if errs := submitedRecord.Validate(); errs != nil {
+		respondWithValidationErrors(w, "Invalid record.", errs)
 		return
 	}
 
-	parentDomain.UpdateSOA(a.DB, user)
+	if a.recordConflicts(w, parentDomain, submitedRecord) {
+		return
+	}
 
-	respondWithJSON(w, http.StatusOK, submitedRecord)
+	changes := types.ZoneChanges{Created: []types.Record{submitedRecord}}
+	err = parentDomain.ApplyChanges(a.DB, user, "record.create", changes)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, changes.Created[0])
 }
 
 // updateRecord endpoint.
 // @Security ApiKeyAuth
 // @Summary Update record
-// @Description Update a existing record in the database by his ID (not reversible.)
+// @Description Update a existing record in the database by his ID (can be reverted with the domain rollback.)
 // @ID putrecord
 // @Accept  json
 // @Produce  json
 // @Param   record_id      path   int     true  "1"
 // @Success 204
 // @Failure 400,403,404 {object} Response
+// @Failure 409 {object} ConflictResponse
+// @Failure 422 {object} ValidationResponse
 // @Tags Records
 // @Router /record/{record_id} [put]
 func (a *Server) updateRecord(w http.ResponseWriter, r *http.Request) {
@@ -127,30 +193,39 @@ func (a *Server) updateRecord(w http.ResponseWriter, r *http.Request) {
 
 	//Check parent domain permissions
 	d := types.Domain{ID: record.DomainID}
+	setAuditDomain(r, d.ID)
 	err = d.GetDomain(a.DB)
-	if domainVerify(err, w, user, d) {
+	if a.recordVerify(err, w, r, d) {
 		return
 	}
 
-	//Parse the submited domain
+	//Parse the submited record
 	submitedRecord := record
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&submitedRecord); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+	if decodeRecordPayload(w, r, &submitedRecord) {
 		return
 	}
-	defer r.Body.Close()
 
 	//The record ID and Domain ID should still be the same
 	submitedRecord.ID = record.ID
 	submitedRecord.DomainID = record.DomainID
 
-	err = submitedRecord.UpdateRecord(a.DB)
-	if checkSrvErr(err, w) {
+	if recordsVerify(w, r, record, submitedRecord) || rulesVerify(w, r, types.ActionUpdate, record, submitedRecord) {
 		return
 	}
 
-	d.UpdateSOA(a.DB, user)
+	if errs := submitedRecord.Validate(); errs != nil {
+		respondWithValidationErrors(w, "Invalid record.", errs)
+		return
+	}
+
+	if a.recordConflicts(w, d, submitedRecord) {
+		return
+	}
+
+	err = d.ApplyChanges(a.DB, user, "record.update", types.ZoneChanges{Updated: []types.Record{submitedRecord}})
+	if checkSrvErr(err, w) {
+		return
+	}
 
 	respondWithCode(w, http.StatusNoContent)
 }
@@ -158,7 +233,7 @@ func (a *Server) updateRecord(w http.ResponseWriter, r *http.Request) {
 // deleteRecord endpoint.
 // @Security ApiKeyAuth
 // @Summary Delete record
-// @Description Delete a record in the database by his ID (not reversible.)
+// @Description Delete a record in the database by his ID (can be reverted with the domain rollback.)
 // @ID delrecord
 // @Produce  json
 // @Param   record_id      path   int     true  "1"
@@ -184,17 +259,144 @@ func (a *Server) deleteRecord(w http.ResponseWriter, r *http.Request) {
 
 	//Check parent domain permissions
 	d := types.Domain{ID: record.DomainID}
+	setAuditDomain(r, d.ID)
 	err = d.GetDomain(a.DB)
-	if domainVerify(err, w, user, d) {
+	if a.recordVerify(err, w, r, d) {
 		return
 	}
 
-	err = record.DeleteRecord(a.DB)
-	if checkSrvErr(err, w) {
+	if recordsVerify(w, r, record) || rulesVerify(w, r, types.ActionDelete, record) {
 		return
 	}
 
-	d.UpdateSOA(a.DB, user)
+	err = d.ApplyChanges(a.DB, user, "record.delete", types.ZoneChanges{Deleted: []types.Record{record}})
+	if checkSrvErr(err, w) {
+		return
+	}
 
 	respondWithCode(w, http.StatusNoContent)
 }
+
+// batchDomainRecords endpoint.
+// @Security ApiKeyAuth
+// @Summary Batch record changes
+// @Description Apply a list of create / update / delete operations on the domain records in a single transaction.
+// @Description All the operations are validated before anything is written and the SOA is updated only once.
+// @Description The users without editor role on the domain need permission rules allowing every operation.
+// @ID batchrecords
+// @Accept  json
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   operations      body   []types.RecordOperation     true  "Operations"
+// @Success 200 {object} types.ZoneChanges
+// @Failure 400,403,404 {object} Response
+// @Failure 409 {object} ConflictResponse
+// @Failure 422 {object} ValidationResponse
+// @Tags Domains, Records
+// @Router /domain/{domain_id}/records/batch [post]
+func (a *Server) batchDomainRecords(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	d := types.Domain{ID: domainID}
+	err := d.GetDomain(a.DB)
+
+	if a.recordVerify(err, w, r, d) {
+		return
+	}
+
+	//Parse the submited operations
+	var operations []types.RecordOperation
+	if decodeRecordPayload(w, r, &operations) {
+		return
+	}
+
+	existing, err := d.GetDomainRecords(a.DB, -1, -1)
+	if checkSrvErr(err, w) {
+		return
+	}
+	records := make(map[int]types.Record, len(existing))
+	for _, record := range existing {
+		records[record.ID] = record
+	}
+
+	//Validate every operation before writing anything
+	changes := types.ZoneChanges{Created: []types.Record{}, Updated: []types.Record{}, Deleted: []types.Record{}}
+	changed := make(map[int]bool)
+	for i, op := range operations {
+		record := op.Record
+		record.DomainID = d.ID
+
+		if op.Action == "update" || op.Action == "delete" {
+			if _, ok := records[record.ID]; !ok {
+				respondWithError(w, http.StatusNotFound, fmt.Sprintf("Operation %v : record %v not found in this domain.", i, record.ID))
+				return
+			}
+			if changed[record.ID] {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Operation %v : record %v is already changed by another operation.", i, record.ID))
+				return
+			}
+			changed[record.ID] = true
+		}
+
+		switch op.Action {
+		case "create":
+			var empty int //force "nil"
+			record.ID = empty
+		case "update":
+		case "delete":
+			if rulesVerify(w, r, types.ActionDelete, records[record.ID]) {
+				return
+			}
+			changes.Deleted = append(changes.Deleted, records[record.ID])
+			continue
+		default:
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Operation %v : invalid action (create, update or delete).", i))
+			return
+		}
+
+		//Check if record is in the correct domain
+		if !strings.HasSuffix(record.Fqdn, d.Fqdn) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Operation %v : record FQDN end don't correspond to parent domain FQDN.", i))
+			return
+		}
+		if errs := record.Validate(); errs != nil {
+			respondWithValidationErrors(w, fmt.Sprintf("Operation %v : invalid record.", i), errs)
+			return
+		}
+
+		if op.Action == "create" {
+			if rulesVerify(w, r, types.ActionCreate, record) {
+				return
+			}
+			changes.Created = append(changes.Created, record)
+		} else {
+			if rulesVerify(w, r, types.ActionUpdate, records[record.ID], record) {
+				return
+			}
+			changes.Updated = append(changes.Updated, record)
+		}
+	}
+
+	if recordsVerify(w, r, changes.Touched(existing)...) {
+		return
+	}
+
+	if conflict := types.CheckChangesConflicts(d.Fqdn, existing, changes); conflict != nil {
+		respondWithConflict(w, conflict)
+		return
+	}
+
+	if !changes.Empty() {
+		err = d.ApplyChanges(a.DB, user, "records.batch", changes)
+		if checkSrvErr(err, w) {
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, changes)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+//The subjects of permission rules read and change the records their rules match, in batches too
+//Records 1 and 2 are the NS, 3 is www, 4 the SOA and 5 the one matched by the rule
+func TestRecordRules(t *testing.T) {
+	a, admin := newTestServer(t)
+	carol, token := newTestUser(t, a, "carol")
+
+	setup := []struct {
+		path string
+		body string
+	}{
+		{"/api/domain", `{"Fqdn":"example.org."}`},
+		{"/api/rule", fmt.Sprintf(`{"UserID":%v,"DomainID":1,"Pattern":"*.app.example.org.","Types":["TXT"]}`, carol.ID)},
+		{"/api/record", `{"DomainID":1,"Fqdn":"www.example.org.","Type":"A","TTL":300,"Content":"192.0.2.1"}`},
+		{"/api/record", `{"DomainID":1,"Fqdn":"a.app.example.org.","Type":"TXT","TTL":300,"Content":"\"a\""}`},
+	}
+	for _, step := range setup {
+		if status, body := a.request(admin, "POST", step.path, step.body); status != http.StatusOK {
+			t.Fatalf("POST %s : got %d %s", step.path, status, body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		result string //Part of the response body
+	}{
+		{"list the matched records", "GET", "/api/domain/1/records", ``, http.StatusOK, `[{"ID":5,`},
+		{"get a matched record", "GET", "/api/record/5", ``, http.StatusOK, `"a.app.example.org."`},
+		{"get another record", "GET", "/api/record/3", ``, http.StatusForbidden, ``},
+		{"batch allowed", "POST", "/api/domain/1/records/batch", `[{"Action":"create","Record":{"Fqdn":"b.app.example.org.","Type":"TXT","TTL":300,"Content":"\"b\""}},{"Action":"delete","Record":{"ID":5}}]`, http.StatusOK, `"b.app.example.org."`},
+		{"batch create refused", "POST", "/api/domain/1/records/batch", `[{"Action":"create","Record":{"Fqdn":"c.app.example.org.","Type":"A","TTL":300,"Content":"192.0.2.3"}}]`, http.StatusForbidden, ``},
+		{"batch update refused", "POST", "/api/domain/1/records/batch", `[{"Action":"update","Record":{"ID":3,"Fqdn":"www.example.org.","Type":"A","TTL":300,"Content":"192.0.2.3"}}]`, http.StatusForbidden, ``},
+		{"batch delete refused", "POST", "/api/domain/1/records/batch", `[{"Action":"delete","Record":{"ID":1}}]`, http.StatusForbidden, ``},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := a.request(token, test.method, test.path, test.body)
+			if status != test.status || !strings.Contains(body, test.result) {
+				t.Errorf("got %d %s, want %d %s", status, body, test.status, test.result)
+			}
+		})
+	}
+}
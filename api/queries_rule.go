@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//recordVerify : Verify if the domain exist and the user can change its records (editor role, or permission rules on the domain)
+//The rules are kept in the request context for rulesVerify
+func (a *Server) recordVerify(err error, w http.ResponseWriter, r *http.Request, d types.Domain) bool {
+	return a.recordAccess(err, w, r, d, types.RoleEditor, true)
+}
+
+//recordReadVerify : Verify if the domain exist and the user can read its records (viewer role, or permission rules for the records they match)
+func (a *Server) recordReadVerify(err error, w http.ResponseWriter, r *http.Request, d types.Domain) bool {
+	return a.recordAccess(err, w, r, d, types.RoleViewer, false)
+}
+
+//recordAccess : Verify if the domain exist and the user has the required role on it, or permission rules (saved in the request context)
+func (a *Server) recordAccess(err error, w http.ResponseWriter, r *http.Request, d types.Domain, required string, write bool) bool {
+	user := context.Get(r, "user").(types.User)
+	token := context.Get(r, "token").(types.Token)
+
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Domain not found.")
+		return true
+	}
+
+	role, err := user.DomainRole(a.DB, d)
+	if checkSrvErr(err, w) {
+		return true
+	}
+	if !types.RoleAllows(role, required) {
+		rules, err := types.GetSubjectRules(a.DB, d.ID, user.ID, token.ID)
+		if checkSrvErr(err, w) {
+			return true
+		}
+		if len(rules) == 0 {
+			respondWithError(w, http.StatusForbidden, "No access to this domain (no permission).")
+			return true
+		}
+		context.Set(r, "recordRules", rules)
+	}
+	context.Set(r, "domainRole", role)
+
+	if !token.AllowsDomain(d.ID, write) {
+		respondWithError(w, http.StatusForbidden, "No access to this domain (token scope).")
+		return true
+	}
+	return false
+}
+
+//readableRecords : get the records the user can read (all of them for the viewers, the ones matched by its permission rules for the others)
+//Must be used after recordReadVerify
+func readableRecords(r *http.Request, records []types.Record) []types.Record {
+	role, _ := context.Get(r, "domainRole").(string)
+	if types.RoleAllows(role, types.RoleViewer) {
+		return records
+	}
+
+	rules, _ := context.Get(r, "recordRules").([]types.RecordRule)
+	readable := []types.Record{}
+	for _, record := range records {
+		if types.RulesMatch(rules, record) {
+			readable = append(readable, record)
+		}
+	}
+	return readable
+}
+
+//rulesVerify : Verify if the permission rules allow the action on the records (nothing to check for the editors)
+//Must be used after recordVerify
+func rulesVerify(w http.ResponseWriter, r *http.Request, action string, records ...types.Record) bool {
+	role, _ := context.Get(r, "domainRole").(string)
+	if types.RoleAllows(role, types.RoleEditor) {
+		return false
+	}
+
+	rules, _ := context.Get(r, "recordRules").([]types.RecordRule)
+	for _, record := range records {
+		if !types.RulesAllow(rules, action, record) {
+			respondWithError(w, http.StatusForbidden, "No access to this record (permission rules).")
+			return true
+		}
+	}
+	return false
+}
+
+//ruleVerify : get the rule of the request (admins only)
+func (a *Server) ruleVerify(w http.ResponseWriter, r *http.Request) (types.RecordRule, bool) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin { //Non-admin can't manage the rules !
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return types.RecordRule{}, true
+	}
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return types.RecordRule{}, true
+	}
+
+	rule := types.RecordRule{ID: id}
+	err := rule.GetRule(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "Rule not found.")
+		return rule, true
+	}
+	if checkSrvErr(err, w) {
+		return rule, true
+	}
+	return rule, false
+}
+
+//decodeRule : parse the submited rule and check it (subject and domain must exist)
+func (a *Server) decodeRule(w http.ResponseWriter, r *http.Request, rule *types.RecordRule) bool {
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(rule); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return true
+	}
+	defer r.Body.Close()
+
+	d := types.Domain{ID: rule.DomainID}
+	err := d.GetDomain(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "Domain not found.")
+		return true
+	}
+	if checkSrvErr(err, w) {
+		return true
+	}
+
+	if err := rule.Validate(d); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return true
+	}
+
+	if rule.UserID != 0 {
+		err = (&types.User{ID: rule.UserID}).GetUser(a.DB)
+	} else {
+		err = a.DB.First(&types.Token{}, rule.TokenID).Error
+	}
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "Rule subject not found.")
+		return true
+	}
+	return checkSrvErr(err, w)
+}
+
+// getRules endpoint.
+// @Security ApiKeyAuth
+// @Summary Get permission rules
+// @Description List the record permission rules (admin only)
+// @ID rules
+// @Produce  json
+// @Param   user      query   int     false  "2"
+// @Param   token      query   int     false  "3"
+// @Param   domain      query   int     false  "1"
+// @Param   count      query   int     false  "10"
+// @Param   start      query   int     false  "1"
+// @Success 200 {object} []types.RecordRule
+// @Failure 400,403 {object} Response
+// @Tags Rules
+// @Router /rules [get]
+func (a *Server) getRules(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin { //Non-admin can't read the rules !
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return
+	}
+
+	//Parsing request vars
+	vars := r.URL.Query()
+	count, _ := strconv.Atoi(vars.Get("count"))
+	start, _ := strconv.Atoi(vars.Get("start"))
+	count = calcCount(count)
+	start = calcStart(start)
+
+	var filter types.RuleFilter
+	filter.UserID, _ = strconv.Atoi(vars.Get("user"))
+	filter.TokenID, _ = strconv.Atoi(vars.Get("token"))
+	filter.DomainID, _ = strconv.Atoi(vars.Get("domain"))
+
+	rules, err := types.GetRules(a.DB, filter, count, start)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, rules)
+}
+
+// getRule endpoint.
+// @Security ApiKeyAuth
+// @Summary Get permission rule
+// @Description Get a record permission rule by its ID (admin only)
+// @ID rule
+// @Produce  json
+// @Param   rule_id      path   int     true  "1"
+// @Success 200 {object} types.RecordRule
+// @Failure 400,403,404 {object} Response
+// @Tags Rules
+// @Router /rule/{rule_id} [get]
+func (a *Server) getRule(w http.ResponseWriter, r *http.Request) {
+	rule, dbg := a.ruleVerify(w, r)
+	if dbg {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, rule)
+}
+
+// createRule endpoint.
+// @Security ApiKeyAuth
+// @Summary Create permission rule
+// @Description Allow a user (UserID) or a token (TokenID) to change the records of a domain matching an FQDN glob (eg : *.app.example.org.) (admin only).
+// @Description Types are record types as mnemonics or numbers (all but NS and SOA if empty), Actions are create, update and delete (all if empty).
+// @ID newrule
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} types.RecordRule
+// @Failure 400,403 {object} Response
+// @Tags Rules
+// @Router /rule [post]
+func (a *Server) createRule(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin { //Non-admin can't create rules !
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return
+	}
+
+	var submitedRule types.RecordRule
+	if a.decodeRule(w, r, &submitedRule) {
+		return
+	}
+	submitedRule.ID = 0
+	setAuditDomain(r, submitedRule.DomainID)
+
+	err := submitedRule.CreateRule(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, submitedRule)
+}
+
+// updateRule endpoint.
+// @Security ApiKeyAuth
+// @Summary Update permission rule
+// @Description Update a record permission rule by its ID (admin only)
+// @ID putrule
+// @Accept  json
+// @Produce  json
+// @Param   rule_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Rules
+// @Router /rule/{rule_id} [put]
+func (a *Server) updateRule(w http.ResponseWriter, r *http.Request) {
+	rule, dbg := a.ruleVerify(w, r)
+	if dbg {
+		return
+	}
+
+	submitedRule := rule
+	if a.decodeRule(w, r, &submitedRule) {
+		return
+	}
+	submitedRule.ID = rule.ID
+	setAuditDomain(r, submitedRule.DomainID)
+
+	err := submitedRule.UpdateRule(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// deleteRule endpoint.
+// @Security ApiKeyAuth
+// @Summary Delete permission rule
+// @Description Delete a record permission rule by its ID (admin only)
+// @ID delrule
+// @Produce  json
+// @Param   rule_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Rules
+// @Router /rule/{rule_id} [delete]
+func (a *Server) deleteRule(w http.ResponseWriter, r *http.Request) {
+	rule, dbg := a.ruleVerify(w, r)
+	if dbg {
+		return
+	}
+	setAuditDomain(r, rule.DomainID)
+
+	err := rule.DeleteRule(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//MemberPayload : Member added to a team or a domain (or role change)
+type MemberPayload struct {
+	UserID int    `example:"2"`
+	Role   string `example:"editor"`
+}
+
+//teamVerify : get the team of the request and check the user has the required role in it (admins have all the roles)
+func (a *Server) teamVerify(w http.ResponseWriter, r *http.Request, required string) (types.Team, bool) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return types.Team{}, true
+	}
+
+	t := types.Team{ID: id}
+	err := t.GetTeam(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "Team not found.")
+		return t, true
+	}
+	if checkSrvErr(err, w) {
+		return t, true
+	}
+
+	if user.IsAdmin {
+		return t, false
+	}
+	role, err := t.GetRole(a.DB, user.ID)
+	if checkSrvErr(err, w) {
+		return t, true
+	}
+	if !types.RoleAllows(role, required) {
+		respondWithError(w, http.StatusForbidden, "No access to this team (no permission).")
+		return t, true
+	}
+	return t, false
+}
+
+//teamAssignable : check that a domain can be given to the team by the user (editor of the team or admin, 0 is no team)
+func (a *Server) teamAssignable(w http.ResponseWriter, user types.User, teamID int) bool {
+	if teamID == 0 {
+		return false
+	}
+
+	t := types.Team{ID: teamID}
+	err := t.GetTeam(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "Team not found.")
+		return true
+	}
+	if checkSrvErr(err, w) {
+		return true
+	}
+
+	if user.IsAdmin {
+		return false
+	}
+	role, err := t.GetRole(a.DB, user.ID)
+	if checkSrvErr(err, w) {
+		return true
+	}
+	if !types.RoleAllows(role, types.RoleEditor) {
+		respondWithError(w, http.StatusForbidden, "No access to this team (no permission).")
+		return true
+	}
+	return false
+}
+
+//decodeTeam : parse the submited team and check its name (id is the team updated, 0 for a new team)
+func (a *Server) decodeTeam(w http.ResponseWriter, r *http.Request, t *types.Team, id int) bool {
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(t); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return true
+	}
+	defer r.Body.Close()
+	t.ID = id //The ID of the payload is ignored
+
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "The team needs a name.")
+		return true
+	}
+	if t.NameExists(a.DB) {
+		respondWithError(w, http.StatusConflict, "Team with the same name already exists.")
+		return true
+	}
+	return false
+}
+
+// getTeams endpoint.
+// @Security ApiKeyAuth
+// @Summary Get teams
+// @Description List the teams of the user (all the teams for the admins)
+// @ID teams
+// @Produce  json
+// @Param   count      query   int     false  "10"
+// @Param   start      query   int     false  "1"
+// @Success 200 {object} []types.Team
+// @Failure 400,403 {object} Response
+// @Tags Teams
+// @Router /teams [get]
+func (a *Server) getTeams(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	vars := r.URL.Query()
+	count, _ := strconv.Atoi(vars.Get("count"))
+	start, _ := strconv.Atoi(vars.Get("start"))
+
+	count = calcCount(count)
+	start = calcStart(start)
+
+	teams, err := types.GetTeams(a.DB, user, count, start)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, teams)
+}
+
+// getTeam endpoint.
+// @Security ApiKeyAuth
+// @Summary Get team
+// @Description Get a team and its members (members of the team only)
+// @ID team
+// @Produce  json
+// @Param   team_id      path   int     true  "1"
+// @Success 200 {object} types.Team
+// @Failure 400,403,404 {object} Response
+// @Tags Teams
+// @Router /team/{team_id} [get]
+func (a *Server) getTeam(w http.ResponseWriter, r *http.Request) {
+	t, dbg := a.teamVerify(w, r, types.RoleViewer)
+	if dbg {
+		return
+	}
+
+	members, err := t.GetMembers(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+	t.Members = members
+
+	respondWithJSON(w, http.StatusOK, t)
+}
+
+// createTeam endpoint.
+// @Security ApiKeyAuth
+// @Summary Create team
+// @Description Create a team, the user creating it is its first owner
+// @ID newteam
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} types.Team
+// @Failure 400,403,409 {object} Response
+// @Tags Teams
+// @Router /team [post]
+func (a *Server) createTeam(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	var submitedTeam types.Team
+	if a.decodeTeam(w, r, &submitedTeam, 0) {
+		return
+	}
+
+	err := submitedTeam.CreateTeam(a.DB, user)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, submitedTeam)
+}
+
+// updateTeam endpoint.
+// @Security ApiKeyAuth
+// @Summary Update team
+// @Description Rename a team or change its description (owners of the team only)
+// @ID putteam
+// @Accept  json
+// @Produce  json
+// @Param   team_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404,409 {object} Response
+// @Tags Teams
+// @Router /team/{team_id} [put]
+func (a *Server) updateTeam(w http.ResponseWriter, r *http.Request) {
+	t, dbg := a.teamVerify(w, r, types.RoleOwner)
+	if dbg {
+		return
+	}
+
+	submitedTeam := t
+	if a.decodeTeam(w, r, &submitedTeam, t.ID) {
+		return
+	}
+
+	err := submitedTeam.UpdateTeam(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// deleteTeam endpoint.
+// @Security ApiKeyAuth
+// @Summary Delete team
+// @Description Delete a team (owners of the team only). The team must not own domains anymore.
+// @ID delteam
+// @Produce  json
+// @Param   team_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404,409 {object} Response
+// @Tags Teams
+// @Router /team/{team_id} [delete]
+func (a *Server) deleteTeam(w http.ResponseWriter, r *http.Request) {
+	t, dbg := a.teamVerify(w, r, types.RoleOwner)
+	if dbg {
+		return
+	}
+
+	domains, err := t.CountDomains(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if domains > 0 {
+		respondWithError(w, http.StatusConflict, "The team still owns domains.")
+		return
+	}
+
+	err = t.DeleteTeam(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// setTeamMember endpoint.
+// @Security ApiKeyAuth
+// @Summary Add team member
+// @Description Add a user to a team or change its role (owners of the team only). Roles : viewer, editor or owner.
+// @ID newteammember
+// @Accept  json
+// @Produce  json
+// @Param   team_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404,409 {object} Response
+// @Tags Teams
+// @Router /team/{team_id}/members [post]
+func (a *Server) setTeamMember(w http.ResponseWriter, r *http.Request) {
+	t, dbg := a.teamVerify(w, r, types.RoleOwner)
+	if dbg {
+		return
+	}
+
+	var payload MemberPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return
+	}
+	defer r.Body.Close()
+
+	if !types.ValidTeamRole(payload.Role) {
+		respondWithError(w, http.StatusBadRequest, "Unknown role (viewer, editor or owner).")
+		return
+	}
+
+	u := types.User{ID: payload.UserID}
+	err := u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "User not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	err = t.SetMember(a.DB, u.ID, payload.Role)
+	if err == types.ErrLastTeamOwner {
+		respondWithError(w, http.StatusConflict, "The team must keep at least one owner.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// deleteTeamMember endpoint.
+// @Security ApiKeyAuth
+// @Summary Remove team member
+// @Description Remove a user from a team (owners of the team, or the user leaving it)
+// @ID delteammember
+// @Produce  json
+// @Param   team_id      path   int     true  "1"
+// @Param   user_id      path   int     true  "2"
+// @Success 204
+// @Failure 400,403,404,409 {object} Response
+// @Tags Teams
+// @Router /team/{team_id}/members/{user_id} [delete]
+func (a *Server) deleteTeamMember(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	memberID, dbg := getVarID(r, w, "user_id")
+	if dbg {
+		return
+	}
+
+	//Every member can leave the team
+	required := types.RoleOwner
+	if memberID == user.ID {
+		required = types.RoleViewer
+	}
+	t, dbg := a.teamVerify(w, r, required)
+	if dbg {
+		return
+	}
+
+	err := t.RemoveMember(a.DB, memberID)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "Team member not found.")
+		return
+	}
+	if err == types.ErrLastTeamOwner {
+		respondWithError(w, http.StatusConflict, "The team must keep at least one owner.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//NewToken : Token with its secret (only returned at creation)
+type NewToken struct {
+	types.Token
+	Secret string `example:"mY7X2Jt1b0eR4oQ9pH5lZc8nV3aK6wFs-dGuIyTqE_0"`
+}
+
+//tokenUser : get the user of the tokens endpoints and check the permissions
+func (a *Server) tokenUser(w http.ResponseWriter, r *http.Request) (types.User, bool) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return types.User{}, true
+	}
+
+	u := types.User{ID: id}
+	err := u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return u, true
+	}
+	if checkSrvErr(err, w) {
+		return u, true
+	}
+
+	if !havePermissions(user, u) {
+		respondWithError(w, http.StatusForbidden, "No access to this user (no permission).")
+		return u, true
+	}
+	return u, false
+}
+
+// getUserTokens endpoint.
+// @Security ApiKeyAuth
+// @Summary Get user tokens
+// @Description List the API tokens of a user (without their secret)
+// @ID usertokens
+// @Produce  json
+// @Param   user_id      path   int     true  "1"
+// @Success 200 {object} []types.Token
+// @Failure 400,403,404 {object} Response
+// @Tags Users, Tokens
+// @Router /user/{user_id}/tokens [get]
+func (a *Server) getUserTokens(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.tokenUser(w, r)
+	if dbg {
+		return
+	}
+
+	tokens, err := u.GetUserTokens(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+// createUserToken endpoint.
+// @Security ApiKeyAuth
+// @Summary Create user token
+// @Description Create a named API token for a user. Scopes : read, write, admin (admin users only) or records:<domain_id>.
+// @Description The secret is only returned in this response.
+// @ID newusertoken
+// @Accept  json
+// @Produce  json
+// @Param   user_id      path   int     true  "1"
+// @Success 200 {object} NewToken
+// @Failure 400,403,404 {object} Response
+// @Tags Users, Tokens
+// @Router /user/{user_id}/tokens [post]
+func (a *Server) createUserToken(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	u, dbg := a.tokenUser(w, r)
+	if dbg {
+		return
+	}
+
+	//Parse the submited token
+	var submitedToken types.Token
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&submitedToken); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return
+	}
+	defer r.Body.Close()
+
+	submitedToken.Name = strings.TrimSpace(submitedToken.Name)
+	if submitedToken.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "The token needs a name.")
+		return
+	}
+	if len(submitedToken.Scopes) == 0 {
+		submitedToken.Scopes = u.DefaultScopes()
+		if !user.IsAdmin { //Only admins can give the admin scope
+			submitedToken.Scopes = types.Scopes{types.ScopeWrite}
+		}
+	}
+	if err := submitedToken.Scopes.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if submitedToken.HasScope(types.ScopeAdmin) && (!u.IsAdmin || !user.IsAdmin) {
+		respondWithError(w, http.StatusForbidden, "The admin scope is only available to admins.")
+		return
+	}
+	if submitedToken.ExpiresAt != nil && submitedToken.ExpiresAt.Before(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "The expiration date is already passed.")
+		return
+	}
+
+	//Define values
+	var empty int //force "nil"
+	submitedToken.ID = empty
+	submitedToken.UserID = u.ID
+	submitedToken.Secret = GenerateToken()
+	submitedToken.LastUsedAt = nil
+
+	err := submitedToken.CreateToken(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NewToken{Token: submitedToken, Secret: submitedToken.Secret})
+}
+
+// deleteUserToken endpoint.
+// @Security ApiKeyAuth
+// @Summary Revoke user token
+// @Description Delete an API token of a user
+// @ID delusertoken
+// @Produce  json
+// @Param   user_id      path   int     true  "1"
+// @Param   token_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Users, Tokens
+// @Router /user/{user_id}/tokens/{token_id} [delete]
+func (a *Server) deleteUserToken(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.tokenUser(w, r)
+	if dbg {
+		return
+	}
+
+	tokenID, dbg := getVarID(r, w, "token_id")
+	if dbg {
+		return
+	}
+
+	token := types.Token{ID: tokenID}
+	err := token.GetToken(a.DB)
+	if err == gorm.ErrRecordNotFound || token.UserID != u.ID {
+		respondWithError(w, http.StatusNotFound, "Token not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := types.DeleteSubjectRules(tx, 0, token.ID); err != nil {
+			return err
+		}
+		return token.DeleteToken(tx)
+	})
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
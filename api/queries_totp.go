@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//TOTPEnrolment : TOTP secret of an enrolment (to add in an authenticator app)
+type TOTPEnrolment struct {
+	Secret string `example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	URI    string `example:"otpauth://totp/Sacrebleu:admin?algorithm=SHA1&digits=6&issuer=Sacrebleu&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+}
+
+//RecoveryCodes : One-time codes to login without the TOTP device (only returned once)
+type RecoveryCodes struct {
+	RecoveryCodes []string `example:"k3vq-9xal,7mzp-2wfd"`
+}
+
+//TOTPCode : TOTP (or recovery) code submitted to confirm an action
+type TOTPCode struct {
+	Code string `example:"123456"`
+}
+
+//selfUser : get the logged user from the database (the context one can come from a JWT)
+func (a *Server) selfUser(w http.ResponseWriter, r *http.Request) (types.User, bool) {
+	user := context.Get(r, "user").(types.User)
+	u := types.User{ID: user.ID}
+	err := u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return u, true
+	}
+	if checkSrvErr(err, w) {
+		return u, true
+	}
+	return u, false
+}
+
+//decodeTOTPCode : parse the submitted code
+func decodeTOTPCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var submitedCode TOTPCode
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&submitedCode); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return "", true
+	}
+	defer r.Body.Close()
+	return submitedCode.Code, false
+}
+
+// enrollTOTP endpoint.
+// @Security ApiKeyAuth
+// @Summary Start TOTP enrolment
+// @Description Generate a new TOTP secret for the logged user. It is required at login once confirmed.
+// @ID totpenroll
+// @Produce  json
+// @Success 200 {object} TOTPEnrolment
+// @Failure 403,409 {object} Response
+// @Tags Users, TOTP
+// @Router /user/self/totp [post]
+func (a *Server) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.selfUser(w, r)
+	if dbg {
+		return
+	}
+	if u.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "TOTP is already enabled (disable it first).")
+		return
+	}
+
+	err := u.GenerateTOTPSecret()
+	if checkSrvErr(err, w) {
+		return
+	}
+	err = u.SaveTOTPSecret(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, TOTPEnrolment{Secret: u.TOTPSecret, URI: u.TOTPURI()})
+}
+
+// confirmTOTP endpoint.
+// @Security ApiKeyAuth
+// @Summary Confirm TOTP enrolment
+// @Description Enable TOTP for the logged user with a code of the authenticator app, and get the recovery codes
+// @ID totpconfirm
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} RecoveryCodes
+// @Failure 400,403,409 {object} Response
+// @Tags Users, TOTP
+// @Router /user/self/totp/confirm [post]
+func (a *Server) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.selfUser(w, r)
+	if dbg {
+		return
+	}
+	code, dbg := decodeTOTPCode(w, r)
+	if dbg {
+		return
+	}
+
+	if u.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, "TOTP is already enabled.")
+		return
+	}
+	if u.TOTPSecret == "" {
+		respondWithError(w, http.StatusBadRequest, "No TOTP enrolment started.")
+		return
+	}
+	if !u.CheckTOTP(a.DB, code) {
+		respondWithError(w, http.StatusForbidden, "Invalid TOTP code.")
+		return
+	}
+
+	err := u.EnableTOTP(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+	codes, err := u.GenerateRecoveryCodes(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+}
+
+// renewRecoveryCodes endpoint.
+// @Security ApiKeyAuth
+// @Summary Renew recovery codes
+// @Description Replace the recovery codes of the logged user (requires a TOTP code)
+// @ID totprecovery
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} RecoveryCodes
+// @Failure 400,403 {object} Response
+// @Tags Users, TOTP
+// @Router /user/self/totp/recovery [post]
+func (a *Server) renewRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	u, dbg := a.selfUser(w, r)
+	if dbg {
+		return
+	}
+	code, dbg := decodeTOTPCode(w, r)
+	if dbg {
+		return
+	}
+
+	if !u.TOTPEnabled {
+		respondWithError(w, http.StatusBadRequest, "TOTP is not enabled.")
+		return
+	}
+	if !u.CheckTOTP(a.DB, code) {
+		respondWithError(w, http.StatusForbidden, "Invalid TOTP code.")
+		return
+	}
+
+	codes, err := u.GenerateRecoveryCodes(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+}
+
+// disableTOTP endpoint.
+// @Security ApiKeyAuth
+// @Summary Disable TOTP
+// @Description Disable TOTP for a user. Users need a TOTP or recovery code, admins can disable it for other users without code (lost device).
+// @ID totpdisable
+// @Accept  json
+// @Produce  json
+// @Param   user_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Users, TOTP
+// @Router /user/{user_id}/totp [delete]
+func (a *Server) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	u := types.User{ID: id}
+	err := u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	if !havePermissions(user, u) {
+		respondWithError(w, http.StatusForbidden, "No access to this user (no permission).")
+		return
+	}
+
+	//Users (and admins for themselves) must prove they own the second factor
+	if user.ID == u.ID && u.TOTPEnabled {
+		code, dbg := decodeTOTPCode(w, r)
+		if dbg {
+			return
+		}
+		if !u.CheckSecondFactor(a.DB, code) {
+			respondWithError(w, http.StatusForbidden, "Invalid TOTP code.")
+			return
+		}
+	}
+
+	err = u.DisableTOTP(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//NewTSIGKey : TSIG key with its secret (only returned at creation and when the secret is regenerated)
+type NewTSIGKey struct {
+	types.TSIGKey
+	Secret string `example:"pQ3x8y4Vt0m2nB7cK1dE9fG6hJ5kL3zX0wR8sT2uY4o="`
+}
+
+//TSIGKeyDomainPayload : Domain to attach a TSIG key to, with the key permissions on it
+type TSIGKeyDomainPayload struct {
+	DomainID    int      `example:"1"`
+	Permissions []string `example:"transfer,update"`
+}
+
+//generateTSIGSecret : generate a random base64 secret as long as the algorithm digest
+func generateTSIGSecret(algorithm string) string {
+	size := 32
+	if algorithm == "hmac-sha512" {
+		size = 64
+	}
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+//tsigAdminVerify : check that the user is an admin (the TSIG keys are managed by the admins only)
+func tsigAdminVerify(w http.ResponseWriter, r *http.Request) bool {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin { //Non-admin can't manage the TSIG keys !
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return true
+	}
+	return false
+}
+
+//tsigKeyVerify : get the TSIG key of the request (admins only)
+func (a *Server) tsigKeyVerify(w http.ResponseWriter, r *http.Request) (types.TSIGKey, bool) {
+	if tsigAdminVerify(w, r) {
+		return types.TSIGKey{}, true
+	}
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return types.TSIGKey{}, true
+	}
+
+	key := types.TSIGKey{ID: id}
+	err := key.GetTSIGKey(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "TSIG key not found.")
+		return key, true
+	}
+	if checkSrvErr(err, w) {
+		return key, true
+	}
+	return key, false
+}
+
+//decodeTSIGKey : parse the submited key and check it (name unique, also among the keys of the configuration) (id is the key updated, 0 for a new key)
+func (a *Server) decodeTSIGKey(w http.ResponseWriter, r *http.Request, key *types.TSIGKey, id int) bool {
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(key); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return true
+	}
+	defer r.Body.Close()
+	key.ID = id //The ID of the payload is ignored
+
+	if err := key.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return true
+	}
+
+	exists, err := key.NameExists(a.DB)
+	if checkSrvErr(err, w) {
+		return true
+	}
+	if _, ok := a.keyring.configKey(key.Name); exists || ok {
+		respondWithError(w, http.StatusConflict, "A TSIG key already has this name.")
+		return true
+	}
+	return false
+}
+
+// getTSIGKeys endpoint.
+// @Security ApiKeyAuth
+// @Summary Get TSIG keys
+// @Description List the TSIG keys of the zone transfers and dynamic updates (admin only)
+// @ID tsigkeys
+// @Produce  json
+// @Param   count      query   int     false  "10"
+// @Param   start      query   int     false  "1"
+// @Success 200 {object} []types.TSIGKey
+// @Failure 400,403 {object} Response
+// @Tags TSIG
+// @Router /tsigkeys [get]
+func (a *Server) getTSIGKeys(w http.ResponseWriter, r *http.Request) {
+	if tsigAdminVerify(w, r) {
+		return
+	}
+
+	//Parsing request vars
+	vars := r.URL.Query()
+	count, _ := strconv.Atoi(vars.Get("count"))
+	start, _ := strconv.Atoi(vars.Get("start"))
+	count = calcCount(count)
+	start = calcStart(start)
+
+	keys, err := types.GetTSIGKeys(a.DB, count, start)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// getTSIGKey endpoint.
+// @Security ApiKeyAuth
+// @Summary Get TSIG key
+// @Description Get a TSIG key by its ID, without its secret (admin only)
+// @ID tsigkey
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 200 {object} types.TSIGKey
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id} [get]
+func (a *Server) getTSIGKey(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, key)
+}
+
+// createTSIGKey endpoint.
+// @Security ApiKeyAuth
+// @Summary Create TSIG key
+// @Description Create a TSIG key (Name and Algorithm : hmac-sha256 or hmac-sha512), its secret is generated and only returned in this response (admin only).
+// @Description The key can't do anything until it is attached to domains.
+// @ID newtsigkey
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} NewTSIGKey
+// @Failure 400,403,409 {object} Response
+// @Tags TSIG
+// @Router /tsigkey [post]
+func (a *Server) createTSIGKey(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if tsigAdminVerify(w, r) {
+		return
+	}
+
+	var submitedKey types.TSIGKey
+	if a.decodeTSIGKey(w, r, &submitedKey, 0) {
+		return
+	}
+	submitedKey.UserID = user.ID
+	submitedKey.Secret = generateTSIGSecret(submitedKey.Algorithm)
+
+	err := submitedKey.CreateTSIGKey(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NewTSIGKey{TSIGKey: submitedKey, Secret: submitedKey.Secret})
+}
+
+// updateTSIGKey endpoint.
+// @Security ApiKeyAuth
+// @Summary Update TSIG key
+// @Description Rename a TSIG key or change its algorithm (a new secret is generated if the algorithm changes) (admin only)
+// @ID puttsigkey
+// @Accept  json
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 200 {object} NewTSIGKey
+// @Success 204
+// @Failure 400,403,404,409 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id} [put]
+func (a *Server) updateTSIGKey(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	submitedKey := key
+	if a.decodeTSIGKey(w, r, &submitedKey, key.ID) {
+		return
+	}
+	submitedKey.Secret = key.Secret
+
+	//The secret length depends on the algorithm
+	rotated := submitedKey.Algorithm != key.Algorithm
+	if rotated {
+		submitedKey.Secret = generateTSIGSecret(submitedKey.Algorithm)
+	}
+
+	err := submitedKey.UpdateTSIGKey(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	if rotated {
+		respondWithJSON(w, http.StatusOK, NewTSIGKey{TSIGKey: submitedKey, Secret: submitedKey.Secret})
+		return
+	}
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// rotateTSIGKey endpoint.
+// @Security ApiKeyAuth
+// @Summary Regenerate TSIG key secret
+// @Description Replace the secret of a TSIG key, the new one is only returned in this response (admin only)
+// @ID rotatetsigkey
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 200 {object} NewTSIGKey
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id}/secret [post]
+func (a *Server) rotateTSIGKey(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	key.Secret = generateTSIGSecret(key.Algorithm)
+	err := key.UpdateTSIGKey(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NewTSIGKey{TSIGKey: key, Secret: key.Secret})
+}
+
+// deleteTSIGKey endpoint.
+// @Security ApiKeyAuth
+// @Summary Delete TSIG key
+// @Description Delete a TSIG key by its ID and detach it from its domains (admin only)
+// @ID deltsigkey
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id} [delete]
+func (a *Server) deleteTSIGKey(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	err := key.DeleteTSIGKey(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// getTSIGKeyDomains endpoint.
+// @Security ApiKeyAuth
+// @Summary Get TSIG key domains
+// @Description List the domains the TSIG key is attached to and its permissions on them (admin only)
+// @ID tsigkeydomains
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 200 {object} []types.TSIGKeyDomain
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id}/domains [get]
+func (a *Server) getTSIGKeyDomains(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	domains, err := key.GetDomains(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, domains)
+}
+
+// setTSIGKeyDomain endpoint.
+// @Security ApiKeyAuth
+// @Summary Attach TSIG key to domain
+// @Description Attach the TSIG key to a domain or change its permissions on it (admin only).
+// @Description Permissions : transfer (AXFR and IXFR) and update (RFC 2136 dynamic updates of the records but NS and SOA).
+// @ID newtsigkeydomain
+// @Accept  json
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id}/domains [post]
+func (a *Server) setTSIGKeyDomain(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+
+	var payload TSIGKeyDomainPayload
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := types.ValidTSIGPermissions(payload.Permissions); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	d := types.Domain{ID: payload.DomainID}
+	err := d.GetDomain(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusBadRequest, "Domain not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+	setAuditDomain(r, d.ID)
+
+	err = key.SetDomain(a.DB, d.ID, payload.Permissions)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// deleteTSIGKeyDomain endpoint.
+// @Security ApiKeyAuth
+// @Summary Detach TSIG key from domain
+// @Description Remove every permission of the TSIG key on the domain (admin only)
+// @ID deltsigkeydomain
+// @Produce  json
+// @Param   key_id      path   int     true  "1"
+// @Param   domain_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags TSIG
+// @Router /tsigkey/{key_id}/domains/{domain_id} [delete]
+func (a *Server) deleteTSIGKeyDomain(w http.ResponseWriter, r *http.Request) {
+	key, dbg := a.tsigKeyVerify(w, r)
+	if dbg {
+		return
+	}
+	domainID, dbg := getVarID(r, w, "domain_id")
+	if dbg {
+		return
+	}
+	setAuditDomain(r, domainID)
+
+	err := key.RemoveDomain(a.DB, domainID)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "TSIG key domain not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithCode(w, http.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTSIGKeyNames(t *testing.T) {
+	a, admin := newTestServer(t)
+	for _, name := range []string{"first.", "second."} {
+		if code, body := a.request(admin, "POST", "/api/tsigkey", `{"Name":"`+name+`","Algorithm":"hmac-sha256"}`); code != http.StatusOK {
+			t.Fatalf("can't create the key %s : %d %s", name, code, body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		reply  string
+	}{
+		{"create with a taken name and its ID", "POST", "/api/tsigkey", `{"ID":1,"Name":"first.","Algorithm":"hmac-sha256"}`, http.StatusConflict, ""},
+		{"create", "POST", "/api/tsigkey", `{"ID":1,"Name":"third.","Algorithm":"hmac-sha256"}`, http.StatusOK, `"ID":3`},
+		{"rename with a taken name", "PUT", "/api/tsigkey/2", `{"Name":"first.","Algorithm":"hmac-sha256"}`, http.StatusConflict, ""},
+		{"rename with another ID", "PUT", "/api/tsigkey/2", `{"ID":1,"Name":"first.","Algorithm":"hmac-sha256"}`, http.StatusConflict, ""},
+		{"keep the name", "PUT", "/api/tsigkey/2", `{"Name":"second.","Algorithm":"hmac-sha256"}`, http.StatusNoContent, ""},
+		{"keep the name with another ID", "PUT", "/api/tsigkey/2", `{"ID":1,"Name":"second.","Algorithm":"hmac-sha256"}`, http.StatusNoContent, ""},
+		{"the first key is unchanged", "GET", "/api/tsigkey/1", ``, http.StatusOK, `"Name":"first."`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, body := a.request(admin, test.method, test.path, test.body)
+			if code != test.code || !strings.Contains(body, test.reply) {
+				t.Errorf("got %d %s, want %d %s", code, body, test.code, test.reply)
+			}
+		})
+	}
+}
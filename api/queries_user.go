@@ -7,10 +7,60 @@ import (
 	"github.com/gorilla/context"
 	"github.com/outout14/sacrebleu-api/api/types"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+//UserInfo : User as returned by the API (without password hash)
+type UserInfo struct {
+	ID       int    `example:"1"`
+	Email    string `example:"admin@example.org"`
+	Username string `example:"admin"`
+	IsAdmin  bool   `example:"true"`
+	TOTP     bool   `example:"true"` //Login requires a TOTP code
+
+	EmailVerified bool   `example:"true"`
+	Provider      string `example:"local"` //Authentication provider (local, oidc)
+}
+
+//UserToken : User with the secret of its new token (only returned once)
+type UserToken struct {
+	UserInfo
+	Token string `example:"mY7X2Jt1b0eR4oQ9pH5lZc8nV3aK6wFs-dGuIyTqE_0"`
+}
+
+//UserPayload : User submitted to create or update it (admins can choose its login provider)
+type UserPayload struct {
+	types.User
+	Provider *string `example:"ldap"`
+}
+
+//setProvider : check and set the login provider of the submitted user
+func (a *Server) setProvider(w http.ResponseWriter, user types.User, payload *UserPayload) bool {
+	if payload.Provider == nil || *payload.Provider == payload.User.Provider {
+		return false
+	}
+	if !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "Only admins can change the login provider.")
+		return true
+	}
+	if *payload.Provider == types.ProviderOIDC {
+		respondWithError(w, http.StatusBadRequest, "Accounts are linked to the single sign-on by their owners (POST /user/self/oidc).")
+		return true
+	}
+	if _, ok := a.providers[*payload.Provider]; !ok {
+		respondWithError(w, http.StatusBadRequest, "Unknown or not configured login provider.")
+		return true
+	}
+	payload.User.Provider = *payload.Provider
+	payload.User.ExternalID = "" //Linked again by the owner
+	return false
+}
+
+//newUserInfo : public informations of the user
+func newUserInfo(u types.User) UserInfo {
+	return UserInfo{ID: u.ID, Email: u.Email, Username: u.Username, IsAdmin: u.IsAdmin, TOTP: u.TOTPEnabled, EmailVerified: u.EmailVerified, Provider: u.Provider}
+}
+
 //Check if user have access to the requested user (reqUser).
 func havePermissions(user types.User, reqUser types.User) bool {
 	if user.IsAdmin {
@@ -26,34 +76,80 @@ func havePermissions(user types.User, reqUser types.User) bool {
 
 // login endpoint.
 // @Summary Login
-// @Description User send his credentials via POST and get his token
+// @Description User send his credentials via POST and get a new session : a short lived JWT access token and a refresh token
+// @Description Too many failed logins from an IP or for an account lock them for a while (429).
 // @ID login
 // @Produce json
-// @Success 200 {object} types.User
-// @Failure 400,403,404 {object} Response
+// @Param   username      formData   string     true  "admin"
+// @Param   password      formData   string     true  "password"
+// @Param   otp      formData   string     false  "TOTP or recovery code (required if TOTP is enabled)"
+// @Success 200 {object} UserSession
+// @Failure 400,401,403,404,409,429 {object} Response
 // @Tags Users
 // @Router /login [post]
 func (a *Server) login(w http.ResponseWriter, r *http.Request) {
+	if a.Config.OIDC.DisableLocalLogin {
+		respondWithError(w, http.StatusForbidden, "Password login is disabled, use the single sign-on.")
+		return
+	}
+
 	submitedUser := types.User{}
 	submitedUser.Username = r.FormValue("username")
 	submitedUser.Password = r.FormValue("password")
 
-	resultUser := submitedUser
+	//Brute-force protection
+	lockedUntil, err := a.throttle.lockedUntil(a.sourceIP(r), submitedUser.Username)
+	if checkSrvErr(err, w) {
+		return
+	}
+	if !lockedUntil.IsZero() {
+		respondLocked(w, lockedUntil, "Too many failed logins, try again later.")
+		return
+	}
 
-	err := resultUser.GetUserByUsername(a.DB)
+	//Check the password with the provider of the user (local, ldap)
+	resultUser, err := a.authenticate(submitedUser.Username, submitedUser.Password)
+	if err == errInvalidCredentials {
+		a.loginFailed(w, r, submitedUser.Username, resultUser.ID)
+		return
+	}
+	if err == errLDAPIdentityTaken {
+		logrus.WithFields(logrus.Fields{"username": submitedUser.Username}).Warning("AUTH : The username or the email of the directory is used by another account.")
+		respondWithError(w, http.StatusConflict, "The username or the email of the directory is already used by another account.")
+		return
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"username": submitedUser.Username}).Errorf("AUTH : Can't check the credentials : %s", err)
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
 
-	if err == gorm.ErrRecordNotFound {
-		respondWithError(w, http.StatusForbidden, "Credentials don't match.")
+	//Second factor
+	if resultUser.TOTPEnabled {
+		otp := r.FormValue("otp")
+		if otp == "" {
+			respondWithError(w, http.StatusUnauthorized, "TOTP code required.")
+			return
+		}
+		if !resultUser.CheckSecondFactor(a.DB, otp) {
+			a.loginFailed(w, r, submitedUser.Username, resultUser.ID)
+			return
+		}
+	}
+
+	err = a.throttle.reset(submitedUser.Username)
+	if checkSrvErr(err, w) {
 		return
 	}
 
-	//Check for the password
-	err = bcrypt.CompareHashAndPassword([]byte(resultUser.Password), []byte(submitedUser.Password))
-	if err != nil {
-		respondWithError(w, http.StatusForbidden, "Credentials don't match.")
-	} else {
-		respondWithJSON(w, http.StatusOK, resultUser)
+	//New session
+	session, err := a.startSession(resultUser)
+	if checkSrvErr(err, w) {
+		return
 	}
+
+	respondWithJSON(w, http.StatusOK, session)
 }
 
 // getUser endpoint.
@@ -63,7 +159,7 @@ func (a *Server) login(w http.ResponseWriter, r *http.Request) {
 // @ID user
 // @Produce  json
 // @Param   user_id      path   int     true  "1"
-// @Success 200 {object} types.User
+// @Success 200 {object} UserInfo
 // @Failure 400,403,404 {object} Response
 // @Tags Users
 // @Router /user/{user_id} [get]
@@ -91,7 +187,7 @@ func (a *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, u)
+	respondWithJSON(w, http.StatusOK, newUserInfo(u))
 }
 
 // getUserSelf endpoint.
@@ -100,22 +196,32 @@ func (a *Server) getUser(w http.ResponseWriter, r *http.Request) {
 // @Description Get the user object of who is running the query.
 // @ID userself
 // @Produce  json
-// @Success 200 {object} types.User
+// @Success 200 {object} UserInfo
+// @Failure 403,404 {object} Response
 // @Tags Users
 // @Router /user/self [get]
 func (a *Server) getUserSelf(w http.ResponseWriter, r *http.Request) {
-	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
-	respondWithJSON(w, http.StatusOK, user)
+	user := context.Get(r, "user").(types.User)
+
+	//The sessions only carry the ID, the username and the email of the user
+	u, dbg := a.selfUser(w, r)
+	if dbg {
+		return
+	}
+	u.IsAdmin = user.IsAdmin //Admin rights of the token (given by its scopes)
+
+	respondWithJSON(w, http.StatusOK, newUserInfo(u))
 }
 
 // createUser endpoint.
 // @Security ApiKeyAuth
 // @Summary Create user
-// @Description Create a user in the database
+// @Description Create a user in the database with a default token
+// @Description The login provider (local, ldap) can be set with Provider (accounts are linked to the single sign-on by their owners).
 // @ID newuser
 // @Accept  json
 // @Produce  json
-// @Success 204 {object} types.User
+// @Success 204 {object} UserToken
 // @Failure 400,403,404,409 {object} Response
 // @Tags Users
 // @Router /user [post]
@@ -128,18 +234,21 @@ func (a *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Parse the submited user
-	var submitedUser types.User
+	var payload UserPayload
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&submitedUser); err != nil {
+	if err := decoder.Decode(&payload); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
 		return
 	}
 	defer r.Body.Close()
+	if a.setProvider(w, user, &payload) {
+		return
+	}
+	submitedUser := payload.User
 
 	//Define values
 	var empty int //force "nil"
 	submitedUser.ID = empty
-	submitedUser.Token = GenerateToken(submitedUser.Email)
 	submitedUser.Password, _ = HashPassword(submitedUser.Password)
 
 	err := submitedUser.CreateUser(a.DB)
@@ -151,18 +260,26 @@ func (a *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, submitedUser)
+	//Default token of the user
+	token := types.Token{UserID: submitedUser.ID, Name: "default", Secret: GenerateToken(), Scopes: submitedUser.DefaultScopes()}
+	err = token.CreateToken(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, UserToken{UserInfo: newUserInfo(submitedUser), Token: token.Secret})
 }
 
 // updateUser endpoint.
 // @Security ApiKeyAuth
 // @Summary Update user informations
 // @Description Update a user in the database by his ID (not reversible.)
+// @Description Admins can change the login provider (local, ldap) with Provider (accounts are linked to the single sign-on by their owners).
 // @ID user
 // @Accept  json
 // @Produce  json
 // @Param   user_id      path   int     true  "1"
-// @Success 200 {object} types.User
+// @Success 200 {object} UserInfo
 // @Failure 400,403,404 {object} Response
 // @Tags Users
 // @Router /user/{user_id} [put]
@@ -187,13 +304,17 @@ func (a *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Parse the submited user
-	submitedUser := u
+	payload := UserPayload{User: u}
 	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&submitedUser); err != nil {
+	if err := decoder.Decode(&payload); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
 		return
 	}
 	defer r.Body.Close()
+	if a.setProvider(w, user, &payload) {
+		return
+	}
+	submitedUser := payload.User
 
 	//Check if modified username or email already exist
 	if submitedUser.Username != u.Username {
@@ -210,31 +331,48 @@ func (a *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	//The user ID & token should still be the same
+	//The user ID should still be the same
 	submitedUser.ID = u.ID
-	submitedUser.Token = u.Token
-	if !user.IsAdmin {
-		submitedUser.IsAdmin = false
+	if submitedUser.Email != u.Email { //The new address must be verified
+		submitedUser.EmailVerified = false
+	}
+	if !user.IsAdmin { //Only admins can change admin rights
+		submitedUser.IsAdmin = u.IsAdmin
+	}
+	if submitedUser.Password != u.Password { //Only hash a new password
+		submitedUser.Password, _ = HashPassword(submitedUser.Password)
 	}
-	submitedUser.Password, _ = HashPassword(submitedUser.Password)
 
-	err = submitedUser.UpdateUser(a.DB)
+	//The sessions carry the admin rights in their access tokens : they are revoked when the rights change
+	var sessions []types.Session
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := submitedUser.UpdateUser(tx); err != nil {
+			return err
+		}
+		if submitedUser.IsAdmin == u.IsAdmin {
+			return nil
+		}
+		sessions, err = submitedUser.RevokeSessions(tx)
+		return err
+	})
 	if checkSrvErr(err, w) {
 		return
 	}
+	a.revokeSessions(sessions)
 
-	respondWithJSON(w, http.StatusOK, submitedUser)
+	respondWithJSON(w, http.StatusOK, newUserInfo(submitedUser))
 }
 
 // deleteUser endpoint.
 // @Security ApiKeyAuth
 // @Summary Delete user
-// @Description Delete a user in the database by his ID (not reversible.)
+// @Description Delete a user in the database by his ID (not reversible.) Its sessions are revoked, its API tokens and the TSIG keys it created are deleted.
+// @Description The last owner of a team can't be deleted (the ownership must be given to another member first).
 // @ID user
 // @Produce  json
 // @Param   user_id      path   int     true  "1"
 // @Success 204
-// @Failure 400,403,404 {object} Response
+// @Failure 400,403,404,409 {object} Response
 // @Tags Users
 // @Router /user/{user_id} [delete]
 func (a *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
@@ -257,7 +395,74 @@ func (a *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = u.DeleteUser(a.DB)
+	//The team domains stay in the teams, only the memberships are removed
+	var sessions []types.Session
+	err = a.DB.Transaction(func(tx *gorm.DB) error {
+		if err := types.DeleteUserMemberships(tx, u.ID); err != nil {
+			return err
+		}
+		if err := types.DeleteSubjectRules(tx, u.ID, 0); err != nil {
+			return err
+		}
+		//The dynamic updates are made in the name of the key creator
+		if err := types.DeleteUserTSIGKeys(tx, u.ID); err != nil {
+			return err
+		}
+		if err := u.DeleteTokens(tx); err != nil {
+			return err
+		}
+		if sessions, err = u.RevokeSessions(tx); err != nil {
+			return err
+		}
+		return u.DeleteUser(tx)
+	})
+	if err == types.ErrLastTeamOwner {
+		respondWithError(w, http.StatusConflict, "The user is the last owner of a team (give the ownership to another member first).")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+	a.revokeSessions(sessions)
+
+	respondWithCode(w, http.StatusNoContent)
+}
+
+// unlockUser endpoint.
+// @Security ApiKeyAuth
+// @Summary Unlock user
+// @Description Remove the lockout and forget the failed logins of a user (admin only)
+// @ID unlockuser
+// @Produce  json
+// @Param   user_id      path   int     true  "1"
+// @Success 204
+// @Failure 400,403,404 {object} Response
+// @Tags Users
+// @Router /user/{user_id}/lock [delete]
+func (a *Server) unlockUser(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	if !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "No access to this functionality (no permission).")
+		return
+	}
+
+	id, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	u := types.User{ID: id}
+	err := u.GetUser(a.DB)
+	if err == gorm.ErrRecordNotFound {
+		respondWithError(w, http.StatusNotFound, "User not found.")
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	err = a.throttle.reset(u.Username)
 	if checkSrvErr(err, w) {
 		return
 	}
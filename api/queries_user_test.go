@@ -0,0 +1,32 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+//The last owner of a team can't be deleted
+func TestDeleteLastTeamOwner(t *testing.T) {
+	a, admin := newTestServer(t)
+	erin, token := newTestUser(t, a, "erin")
+	frank, _ := newTestUser(t, a, "frank")
+
+	steps := []struct {
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{token, "POST", "/api/team", `{"Name":"ops"}`, http.StatusOK},
+		{admin, "DELETE", fmt.Sprintf("/api/user/%v", erin.ID), ``, http.StatusConflict},
+		{admin, "POST", "/api/team/1/members", fmt.Sprintf(`{"UserID":%v,"Role":"owner"}`, frank.ID), http.StatusNoContent},
+		{admin, "DELETE", fmt.Sprintf("/api/user/%v", erin.ID), ``, http.StatusNoContent},
+	}
+	for _, step := range steps {
+		if status, body := a.request(step.token, step.method, step.path, step.body); status != step.status {
+			t.Fatalf("%s %s : got %d %s, want %d", step.method, step.path, status, body, step.status)
+		}
+	}
+}
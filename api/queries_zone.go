@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+	"github.com/outout14/sacrebleu-api/api/types"
+)
+
+//maxZoneSize : maximum size of an imported zone file (10MB)
+const maxZoneSize = 10 << 20
+
+//AXFRImport : Primary server to import the zone from (port 53 by default), with an optional TSIG key
+type AXFRImport struct {
+	Primary   string `example:"192.0.2.1:53"`
+	KeyName   string `example:"transfer.example.org."`
+	Algorithm string `example:"hmac-sha256"`
+	Secret    string `example:"c2VjcmV0c2VjcmV0"`
+}
+
+// getDomainZone endpoint.
+// @Security ApiKeyAuth
+// @Summary Export domain zone
+// @Description Export all the domain records (SOA and NS included) as an RFC 1035 master file
+// @ID domainzone
+// @Produce  plain
+// @Param   domain_id      path   int     true  "1"
+// @Success 200 {string} string "Zone file"
+// @Failure 400,403,404 {object} Response
+// @Tags Domains, Records
+// @Router /domain/{domain_id}/zone [get]
+func (a *Server) getDomainZone(w http.ResponseWriter, r *http.Request) {
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	d := types.Domain{ID: domainID}
+	err := d.GetDomain(a.DB)
+
+	if a.domainVerify(err, w, r, d) {
+		return
+	}
+
+	zone, err := d.ExportZone(a.DB)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithText(w, http.StatusOK, zone)
+}
+
+// importDomainZone endpoint.
+// @Security ApiKeyAuth
+// @Summary Import domain zone
+// @Description Import an RFC 1035 master file ($INCLUDE not allowed) in the domain. The SOA of the file is ignored as it is generated by the API.
+// @Description In merge mode the records are added to the existing ones, in replace mode the records missing from the file are deleted.
+// @ID importdomainzone
+// @Accept  plain
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   mode      query   string     false  "merge or replace"
+// @Param   dryRun      query   bool     false  "false"
+// @Success 200 {object} types.ZoneChanges
+// @Failure 400,403,404 {object} Response
+// @Failure 409 {object} ConflictResponse
+// @Failure 422 {object} ValidationResponse
+// @Tags Domains, Records
+// @Router /domain/{domain_id}/zone [post]
+func (a *Server) importDomainZone(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	//Parsing request vars
+	vars := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(vars.Get("dryRun"))
+	mode := vars.Get("mode")
+	if mode != "" && mode != "merge" && mode != "replace" {
+		respondWithError(w, http.StatusBadRequest, "Invalid mode (merge or replace).")
+		return
+	}
+
+	d := types.Domain{ID: domainID}
+	err := d.GetDomain(a.DB)
+
+	if a.domainVerify(err, w, r, d) {
+		return
+	}
+
+	//Parse the submited zone
+	records, err := d.ParseZone(http.MaxBytesReader(w, r.Body, maxZoneSize))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid zone file : %s", err))
+		return
+	}
+	defer r.Body.Close()
+
+	for _, record := range records {
+		if errs := record.Validate(); errs != nil {
+			respondWithValidationErrors(w, fmt.Sprintf("Invalid record %s %v %s.", record.Fqdn, record.TTL, record.Content), errs)
+			return
+		}
+	}
+
+	existing, err := d.GetDomainRecords(a.DB, -1, -1)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	changes := types.DiffZone(existing, records, mode == "replace")
+	if recordsVerify(w, r, changes.Touched(existing)...) {
+		return
+	}
+	if conflict := types.CheckChangesConflicts(d.Fqdn, existing, changes); conflict != nil {
+		respondWithConflict(w, conflict)
+		return
+	}
+	if dryRun || changes.Empty() {
+		respondWithJSON(w, http.StatusOK, changes)
+		return
+	}
+
+	err = d.ApplyChanges(a.DB, user, "zone.import", changes)
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, changes)
+}
+
+// importDomainAXFR endpoint.
+// @Security ApiKeyAuth
+// @Summary Import domain zone by AXFR
+// @Description Transfer the zone from a primary server (with an optional TSIG key, hmac-sha256 or hmac-sha512) and import its records in the domain.
+// @Description The SOA of the primary is ignored as it is generated by the API, and so are its DNSSEC records. The modes are the ones of the zone file import.
+// @Description Users but the admins can only import from the primaries of the ImportPrimaries networks of the configuration (403).
+// @ID importdomainaxfr
+// @Accept  json
+// @Produce  json
+// @Param   domain_id      path   int     true  "1"
+// @Param   mode      query   string     false  "merge or replace"
+// @Param   dryRun      query   bool     false  "false"
+// @Param   primary      body   AXFRImport     true  "Primary server"
+// @Success 200 {object} types.ZoneTransfer
+// @Failure 400,403,404,502 {object} Response
+// @Failure 409 {object} ConflictResponse
+// @Failure 422 {object} ValidationResponse
+// @Tags Domains, Records
+// @Router /domain/{domain_id}/import/axfr [post]
+func (a *Server) importDomainAXFR(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(types.User) //avoid asking the SQL server again for the user
+
+	domainID, dbg := getID(r, w)
+	if dbg {
+		return
+	}
+
+	//Parsing request vars
+	vars := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(vars.Get("dryRun"))
+	mode := vars.Get("mode")
+	if mode != "" && mode != "merge" && mode != "replace" {
+		respondWithError(w, http.StatusBadRequest, "Invalid mode (merge or replace).")
+		return
+	}
+
+	var payload AXFRImport
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid resquest payload.")
+		return
+	}
+	defer r.Body.Close()
+	if payload.Primary == "" {
+		respondWithError(w, http.StatusBadRequest, "Missing primary server.")
+		return
+	}
+
+	var key *types.TSIGKey
+	if payload.KeyName != "" {
+		key = &types.TSIGKey{Name: payload.KeyName, Algorithm: payload.Algorithm, Secret: payload.Secret}
+		if err := key.Validate(); err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid TSIG key : %s.", err))
+			return
+		}
+	}
+
+	d := types.Domain{ID: domainID}
+	err := d.GetDomain(a.DB)
+
+	if a.domainVerify(err, w, r, d) {
+		return
+	}
+
+	primary, err := a.axfrPrimary(user, payload.Primary)
+	if err != nil {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("Primary server not allowed : %s.", err))
+		return
+	}
+
+	transfer, err := d.ImportTransfer(a.DB, user, primary, key, mode == "replace", dryRun, func(touched []types.Record) bool {
+		return recordsVerify(w, r, touched...)
+	})
+	var transferErr *types.TransferError
+	var recordErr *types.RecordError
+	var conflict *types.ConflictError
+	switch {
+	case err == types.ErrImportRefused: //Already answered
+		return
+	case errors.As(err, &transferErr):
+		respondWithError(w, http.StatusBadGateway, fmt.Sprintf("Zone transfer from %s failed : %s", transferErr.Primary, transferErr.Err))
+		return
+	case errors.As(err, &recordErr):
+		respondWithValidationErrors(w, fmt.Sprintf("Invalid record %s %v %s.", recordErr.Record.Fqdn, recordErr.Record.TTL, recordErr.Record.Content), recordErr.Errors)
+		return
+	case errors.As(err, &conflict):
+		respondWithConflict(w, conflict)
+		return
+	}
+	if checkSrvErr(err, w) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, transfer)
+}
+
+//axfrPrimary : check the primary server of a zone import, the users but the admins can only import from the ImportPrimaries networks
+//The address is returned resolved so that the transfer connects to the checked IP
+func (a *Server) axfrPrimary(user types.User, primary string) (string, error) {
+	if user.IsAdmin {
+		return primary, nil
+	}
+	allowed := types.Networks(a.Config.XFR.ImportPrimaries)
+	if len(allowed) == 0 {
+		return "", errors.New("only admins can import zones by AXFR")
+	}
+
+	host, port, err := net.SplitHostPort(primary)
+	if err != nil {
+		host, port = primary, "53"
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("can't resolve %s", host)
+	}
+	if !allowed.Contains(ips[0].String()) {
+		return "", fmt.Errorf("%s is not in the allowed networks", ips[0])
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAccessLifetime  = 15 * time.Minute
+	defaultRefreshLifetime = 30 * 24 * time.Hour
+	revocationsSync        = time.Minute
+)
+
+var (
+	errTokenInvalid   = errors.New("token invalid")
+	errTokenExpired   = errors.New("token expired")
+	errSessionRevoked = errors.New("session revoked")
+)
+
+//sessionClaims : Claims of the JWT access tokens
+type sessionClaims struct {
+	jwt.StandardClaims
+	SessionID int      `json:"sid"`
+	Username  string   `json:"name"`
+	Email     string   `json:"email"`
+	Scopes    []string `json:"scopes"`
+}
+
+//sessionSigner : Sign and verify the JWT access tokens of the sessions
+type sessionSigner struct {
+	method          jwt.SigningMethod
+	signKey         interface{}
+	verifyKey       interface{}
+	accessLifetime  time.Duration
+	refreshLifetime time.Duration
+	revocations     revocationList
+}
+
+//newSessionSigner : load the signing keys from the [Auth] configuration
+func newSessionSigner(conf Auth) (*sessionSigner, error) {
+	s := &sessionSigner{accessLifetime: conf.AccessLifetime, refreshLifetime: conf.RefreshLifetime}
+	if s.accessLifetime <= 0 {
+		s.accessLifetime = defaultAccessLifetime
+	}
+	if s.refreshLifetime <= 0 {
+		s.refreshLifetime = defaultRefreshLifetime
+	}
+	s.revocations.sessions = make(map[int]time.Time)
+
+	switch conf.SigningMethod {
+	case "", "HS256":
+		s.method = jwt.SigningMethodHS256
+		key := []byte(conf.Key)
+		if len(key) == 0 {
+			logrus.Warning("AUTH : No HMAC key in the [Auth] configuration, a random one is used (sessions are lost on restart).")
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+		}
+		if len(key) < 32 {
+			return nil, errors.New("the HMAC key must be at least 32 characters long")
+		}
+		s.signKey, s.verifyKey = key, key
+	case "EdDSA":
+		s.method = jwt.SigningMethodEdDSA
+		pem, err := ioutil.ReadFile(conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		s.signKey, s.verifyKey = key, key.(ed25519.PrivateKey).Public()
+	default:
+		return nil, fmt.Errorf("unknown signing method %q (HS256 or EdDSA)", conf.SigningMethod)
+	}
+	return s, nil
+}
+
+//sign : create an access token for the session of the user
+func (s *sessionSigner) sign(user types.User, session types.Session) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.accessLifetime)
+	claims := sessionClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        GenerateToken()[:16],
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+		SessionID: session.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Scopes:    session.Scopes,
+	}
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s.signKey)
+	return token, expiresAt, err
+}
+
+//parse : verify the access token (signature, expiration and revocation) and return its claims
+func (s *sessionSigner) parse(raw string) (*sessionClaims, error) {
+	claims := &sessionClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != s.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return s.verifyKey, nil
+	})
+	if err != nil {
+		var verr *jwt.ValidationError
+		if errors.As(err, &verr) && verr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, errTokenExpired
+		}
+		return nil, errTokenInvalid
+	}
+	if s.revocations.revoked(claims.SessionID) {
+		return nil, errSessionRevoked
+	}
+	return claims, nil
+}
+
+//revocationList : Sessions revoked while their access tokens can still be valid
+type revocationList struct {
+	sync.RWMutex
+	sessions map[int]time.Time //Session ID : expiration of its last access token
+}
+
+//revoke : add the session to the list
+func (l *revocationList) revoke(id int, until time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.sessions[id] = until
+}
+
+//revoked : check if the session is in the list
+func (l *revocationList) revoked(id int) bool {
+	l.RLock()
+	defer l.RUnlock()
+	_, ok := l.sessions[id]
+	return ok
+}
+
+//load : add the sessions recently revoked in the database (eg : by another API server) and forget the old ones
+func (l *revocationList) load(db *gorm.DB, accessLifetime time.Duration) error {
+	now := time.Now()
+	sessions, err := types.GetRevokedSessions(db, now.Add(-accessLifetime))
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	for id, until := range l.sessions {
+		if until.Before(now) {
+			delete(l.sessions, id)
+		}
+	}
+	for _, session := range sessions {
+		l.sessions[session.ID] = session.RevokedAt.Add(accessLifetime)
+	}
+	return nil
+}
+
+//revokeSessions : add the sessions revoked in the database to the revocation list (their access tokens are refused at once)
+func (a *Server) revokeSessions(sessions []types.Session) {
+	for _, session := range sessions {
+		a.sessions.revocations.revoke(session.ID, session.RevokedAt.Add(a.sessions.accessLifetime))
+	}
+}
+
+//syncRevocations : keep the revocation list up to date with the database
+func (a *Server) syncRevocations() {
+	for {
+		if err := a.sessions.revocations.load(a.DB, a.sessions.accessLifetime); err != nil {
+			logrus.Errorf("AUTH : Can't load the revoked sessions : %s", err)
+		}
+		time.Sleep(revocationsSync)
+	}
+}
+
+//startSession : create a new session for the user and its first access token
+func (a *Server) startSession(user types.User) (UserSession, error) {
+	user.DeleteExpiredSessions(a.DB)
+
+	session := types.Session{UserID: user.ID, Scopes: user.DefaultScopes(), ExpiresAt: time.Now().Add(a.sessions.refreshLifetime)}
+	err := session.CreateSession(a.DB, GenerateToken())
+	if err != nil {
+		return UserSession{}, err
+	}
+	return a.sessionTokens(user, session)
+}
+
+//sessionTokens : sign an access token for the session and return it with the refresh token
+func (a *Server) sessionTokens(user types.User, session types.Session) (UserSession, error) {
+	token, expiresAt, err := a.sessions.sign(user, session)
+	if err != nil {
+		return UserSession{}, err
+	}
+	return UserSession{UserInfo: newUserInfo(user), Token: token, RefreshToken: session.Secret, ExpiresAt: expiresAt}, nil
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultThrottleWindow     = 15 * time.Minute
+	defaultMaxIPFailures      = 20
+	defaultMaxAccountFailures = 5
+	defaultLockout            = 15 * time.Minute
+)
+
+//ThrottleStore : Storage of the failed logins and lockouts, by target (eg : ip:192.0.2.1, user:admin)
+type ThrottleStore interface {
+	AddFailure(target string, date time.Time) error
+	CountFailures(target string, since time.Time) (int, error) //Failures since the date (the older ones can be forgotten)
+	Lock(target string, until time.Time) error
+	LockedUntil(target string) (time.Time, error) //Zero if not locked
+	Reset(target string) error                    //Forget the failures and the lockout
+}
+
+//memoryThrottleStore : In-process throttle store (for a single API server)
+type memoryThrottleStore struct {
+	mutex    sync.Mutex
+	failures map[string][]time.Time
+	locks    map[string]time.Time
+}
+
+//newMemoryThrottleStore : create the store and forget the expired failures and lockouts every window
+func newMemoryThrottleStore(window time.Duration) *memoryThrottleStore {
+	s := &memoryThrottleStore{failures: make(map[string][]time.Time), locks: make(map[string]time.Time)}
+	go func() {
+		for now := range time.Tick(window) {
+			s.sweep(now.Add(-window), now)
+		}
+	}()
+	return s
+}
+
+//sweep : delete the failures older than since and the lockouts ended before now
+func (s *memoryThrottleStore) sweep(since time.Time, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for target, dates := range s.failures {
+		if recent := recentFailures(dates, since); len(recent) == 0 {
+			delete(s.failures, target)
+		} else {
+			s.failures[target] = recent
+		}
+	}
+	for target, until := range s.locks {
+		if until.Before(now) {
+			delete(s.locks, target)
+		}
+	}
+}
+
+//recentFailures : the failure dates since the date
+func recentFailures(dates []time.Time, since time.Time) []time.Time {
+	recent := []time.Time{}
+	for _, date := range dates {
+		if !date.Before(since) {
+			recent = append(recent, date)
+		}
+	}
+	return recent
+}
+
+func (s *memoryThrottleStore) AddFailure(target string, date time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[target] = append(s.failures[target], date)
+	return nil
+}
+
+func (s *memoryThrottleStore) CountFailures(target string, since time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	recent := recentFailures(s.failures[target], since)
+	if len(recent) == 0 {
+		delete(s.failures, target)
+	} else {
+		s.failures[target] = recent
+	}
+	return len(recent), nil
+}
+
+func (s *memoryThrottleStore) Lock(target string, until time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.locks[target] = until
+	return nil
+}
+
+func (s *memoryThrottleStore) LockedUntil(target string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	until, ok := s.locks[target]
+	if ok && until.Before(time.Now()) {
+		delete(s.locks, target)
+		return time.Time{}, nil
+	}
+	return until, nil
+}
+
+func (s *memoryThrottleStore) Reset(target string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.failures, target)
+	delete(s.locks, target)
+	return nil
+}
+
+//sqlThrottleStore : Throttle store in the database (shared by the API servers)
+type sqlThrottleStore struct {
+	db *gorm.DB
+}
+
+func (s sqlThrottleStore) AddFailure(target string, date time.Time) error {
+	return types.AddLoginFailure(s.db, target, date)
+}
+
+func (s sqlThrottleStore) CountFailures(target string, since time.Time) (int, error) {
+	return types.CountLoginFailures(s.db, target, since)
+}
+
+func (s sqlThrottleStore) Lock(target string, until time.Time) error {
+	return types.SetLoginLock(s.db, target, until)
+}
+
+func (s sqlThrottleStore) LockedUntil(target string) (time.Time, error) {
+	return types.GetLoginLock(s.db, target)
+}
+
+func (s sqlThrottleStore) Reset(target string) error {
+	return types.ResetLogin(s.db, target)
+}
+
+//loginThrottle : Sliding windows of failed logins by IP and by account, with temporary lockouts
+type loginThrottle struct {
+	store              ThrottleStore
+	prefix             string //Prefix of the targets (to count other requests than the logins in the same store)
+	window             time.Duration
+	maxIPFailures      int
+	maxAccountFailures int
+	lockout            time.Duration
+}
+
+//newLoginThrottle : create the throttle from the [Throttle] configuration
+func newLoginThrottle(conf Throttle, db *gorm.DB) (*loginThrottle, error) {
+	t := &loginThrottle{window: conf.Window, maxIPFailures: conf.MaxIPFailures, maxAccountFailures: conf.MaxAccountFailures, lockout: conf.Lockout}
+	if t.window <= 0 {
+		t.window = defaultThrottleWindow
+	}
+	if t.maxIPFailures <= 0 {
+		t.maxIPFailures = defaultMaxIPFailures
+	}
+	if t.maxAccountFailures <= 0 {
+		t.maxAccountFailures = defaultMaxAccountFailures
+	}
+	if t.lockout <= 0 {
+		t.lockout = defaultLockout
+	}
+
+	switch conf.Store {
+	case "", "memory":
+		t.store = newMemoryThrottleStore(t.window)
+	case "sql":
+		t.store = sqlThrottleStore{db: db}
+	default:
+		return nil, fmt.Errorf("unknown throttle store %q (memory or sql)", conf.Store)
+	}
+	return t, nil
+}
+
+//withPrefix : copy of the throttle counting its requests apart from the logins (in the same store)
+func (t *loginThrottle) withPrefix(prefix string) *loginThrottle {
+	c := *t
+	c.prefix = prefix
+	return &c
+}
+
+func (t *loginThrottle) ipTarget(ip string) string {
+	return t.prefix + "ip:" + ip
+}
+
+func (t *loginThrottle) accountTarget(username string) string {
+	return t.prefix + "user:" + strings.ToLower(username)
+}
+
+//lockedUntil : end of the lockout of the IP or the account (zero if none is locked)
+func (t *loginThrottle) lockedUntil(ip string, username string) (time.Time, error) {
+	var until time.Time
+	for _, target := range []string{t.ipTarget(ip), t.accountTarget(username)} {
+		date, err := t.store.LockedUntil(target)
+		if err != nil {
+			return until, err
+		}
+		if date.After(until) {
+			until = date
+		}
+	}
+	return until, nil
+}
+
+//fail : count a failed login of the account from the IP, and lock them if needed
+func (t *loginThrottle) fail(ip string, username string) error {
+	now := time.Now()
+	limits := map[string]int{t.ipTarget(ip): t.maxIPFailures, t.accountTarget(username): t.maxAccountFailures}
+	for target, max := range limits {
+		if err := t.store.AddFailure(target, now); err != nil {
+			return err
+		}
+		count, err := t.store.CountFailures(target, now.Add(-t.window))
+		if err != nil {
+			return err
+		}
+		if count >= max {
+			logrus.WithFields(logrus.Fields{"target": target, "failures": count}).Warning("AUTH : Login locked.")
+			if err := t.store.Lock(target, now.Add(t.lockout)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//reset : forget the failed logins and the lockout of the account (after a login or by an admin)
+func (t *loginThrottle) reset(username string) error {
+	return t.store.Reset(t.accountTarget(username))
+}
+
+//respondLocked : reply to a locked login (or request) with the delay before the next try
+func respondLocked(w http.ResponseWriter, until time.Time, message string) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(time.Until(until).Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, message)
+}
+
+//loginFailed : count the failed login and write it in the audit log
+func (a *Server) loginFailed(w http.ResponseWriter, r *http.Request, username string, userID int) {
+	if err := a.throttle.fail(a.sourceIP(r), username); err != nil {
+		logrus.Errorf("AUTH : Can't count the failed login : %s", err)
+	}
+
+	entry := types.AuditEntry{
+		UserID:     userID,
+		Action:     "login.failed",
+		Method:     r.Method,
+		Endpoint:   r.URL.Path,
+		TargetType: "user",
+		TargetID:   userID,
+		Status:     http.StatusForbidden,
+		SourceIP:   a.sourceIP(r),
+	}
+	if err := entry.CreateAuditEntry(a.DB); err != nil {
+		logrus.WithFields(logrus.Fields{"action": entry.Action, "user": entry.UserID}).Errorf("AUDIT : Can't write entry : %s", err)
+	}
+
+	respondWithError(w, http.StatusForbidden, "Credentials don't match.")
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"gorm.io/gorm"
+)
+
+//tsigFudge : allowed time difference (in seconds) of the signed answers
+const tsigFudge = 300
+
+//tsigAlgorithms : accepted TSIG algorithms (by their short name)
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+//tsigKey : TSIG key of the configuration (with the rights of its user) or of the database (ID set, permissions by domain)
+type tsigKey struct {
+	ID        int
+	Name      string
+	Algorithm string
+	Secret    string
+	Username  string
+}
+
+//mac : compute the HMAC of the message with the key (the algorithm must be the key one)
+func (key tsigKey) mac(msg []byte, t *dns.TSIG) ([]byte, error) {
+	if !strings.EqualFold(dns.CanonicalName(t.Algorithm), key.Algorithm) {
+		return nil, dns.ErrKeyAlg
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var h hash.Hash
+	switch key.Algorithm {
+	case dns.HmacSHA1:
+		h = hmac.New(sha1.New, secret)
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, secret)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, secret)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+//parseTSIGKey : parse a key of the configuration (name:algorithm:base64 secret:username)
+func parseTSIGKey(value string) (tsigKey, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 4 {
+		return tsigKey{}, fmt.Errorf("invalid key %q (name:algorithm:secret:username expected)", value)
+	}
+
+	key := tsigKey{Name: dns.Fqdn(strings.ToLower(parts[0])), Secret: parts[2], Username: parts[3]}
+	if _, ok := dns.IsDomainName(key.Name); !ok {
+		return key, fmt.Errorf("invalid key name %q", parts[0])
+	}
+
+	algorithm, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(parts[1]), ".")]
+	if !ok {
+		return key, fmt.Errorf("unsupported algorithm %q for the key %s", parts[1], key.Name)
+	}
+	key.Algorithm = algorithm
+
+	if _, err := base64.StdEncoding.DecodeString(key.Secret); err != nil || key.Secret == "" {
+		return key, fmt.Errorf("invalid secret for the key %s", key.Name)
+	}
+	return key, nil
+}
+
+//tsigKeyring : TSIG keys of the DNS listeners, from the configuration and the database
+type tsigKeyring struct {
+	db   *gorm.DB
+	keys map[string]tsigKey
+}
+
+//newTSIGKeyring : load the keys of the configuration
+func newTSIGKeyring(values []string, db *gorm.DB) (*tsigKeyring, error) {
+	k := &tsigKeyring{db: db, keys: map[string]tsigKey{}}
+	for _, value := range values {
+		key, err := parseTSIGKey(value)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.Name] = key
+	}
+	return k, nil
+}
+
+//configKey : get a key of the configuration by its name
+func (k *tsigKeyring) configKey(name string) (tsigKey, bool) {
+	key, ok := k.keys[dns.CanonicalName(name)]
+	return key, ok
+}
+
+//key : get a key by its name, from the configuration then from the database
+func (k *tsigKeyring) key(name string) (tsigKey, error) {
+	if key, ok := k.configKey(name); ok {
+		return key, nil
+	}
+
+	stored := types.TSIGKey{Name: name}
+	if err := stored.GetTSIGKeyByName(k.db); err == gorm.ErrRecordNotFound {
+		return tsigKey{}, dns.ErrSecret
+	} else if err != nil {
+		return tsigKey{}, err
+	}
+	return tsigKey{ID: stored.ID, Name: stored.Name, Algorithm: types.TSIGAlgorithms[stored.Algorithm], Secret: stored.Secret}, nil
+}
+
+//Generate : sign a message (TsigProvider of miekg/dns)
+func (k *tsigKeyring) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	key, err := k.key(t.Hdr.Name)
+	if err != nil {
+		return nil, err
+	}
+	return key.mac(msg, t)
+}
+
+//Verify : check the signature of a message (TsigProvider of miekg/dns)
+func (k *tsigKeyring) Verify(msg []byte, t *dns.TSIG) error {
+	key, err := k.key(t.Hdr.Name)
+	if err != nil {
+		return err
+	}
+	expected, err := key.mac(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil || !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
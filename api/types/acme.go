@@ -0,0 +1,144 @@
+package types
+
+import (
+	"crypto/subtle"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//ACMEChallengeKeep : number of TXT values kept for a registration (wildcard and base name certificates need two at once)
+const ACMEChallengeKeep = 2
+
+//Networks : List of CIDR networks, stored space separated in the database
+type Networks []string
+
+//Value : write the networks in the database
+func (n Networks) Value() (driver.Value, error) {
+	return Scopes(n).Value()
+}
+
+//Scan : read the networks from the database
+func (n *Networks) Scan(value interface{}) error {
+	return (*Scopes)(n).Scan(value)
+}
+
+//GormDataType : networks are stored as a string
+func (Networks) GormDataType() string {
+	return "string"
+}
+
+//Validate : check that every network is a valid CIDR
+func (n Networks) Validate() error {
+	for _, network := range n {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid CIDR %q", network)
+		}
+	}
+	return nil
+}
+
+//Contains : check if the IP is in one of the networks (every IP if there is no network)
+func (n Networks) Contains(ip string) bool {
+	if len(n) == 0 {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	for _, network := range n {
+		if _, ipnet, err := net.ParseCIDR(network); err == nil && parsed != nil && ipnet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+//ACMERegistration : Credentials of an acme-dns client, allowed to write the TXT records of its subdomain in the ACME zone only
+type ACMERegistration struct {
+	ID        int       `gorm:"primaryKey" example:"1"`
+	Username  string    `example:"c36f50e8-4632-44f0-83fe-e070fef28a10" gorm:"not null;size:36;uniqueIndex"`
+	Hash      string    `json:"-" gorm:"not null;size:64"`
+	Password  string    `json:"-" gorm:"-"` //Only known at the creation
+	Subdomain string    `example:"8e5700ea-a4bf-41c7-8a77-e990661dcc6a" gorm:"not null;size:36;uniqueIndex"`
+	AllowFrom Networks  `example:"192.0.2.0/24" swaggertype:"array,string" gorm:"not null;"`
+	UserID    int       `example:"2" gorm:"not null;default:0;index"` //User who registered (0 for the open registrations)
+	CreatedAt time.Time `gorm:"not null;"`
+}
+
+//CreateRegistration : create registration in gorm database (the password is hashed)
+func (reg *ACMERegistration) CreateRegistration(db *gorm.DB) error {
+	reg.Hash = hashSecret(reg.Password)
+	result := db.Create(&reg)
+	return result.Error
+}
+
+//GetRegistration : get registration from gorm database (by id)
+func (reg *ACMERegistration) GetRegistration(db *gorm.DB) error {
+	result := db.First(&reg, reg.ID)
+	return result.Error
+}
+
+//GetRegistrationByUsername : get registration from gorm database (by username)
+func (reg *ACMERegistration) GetRegistrationByUsername(db *gorm.DB) error {
+	result := db.Where("username = ?", reg.Username).First(&reg)
+	return result.Error
+}
+
+//GetRegistrations : get the registrations of the user from gorm database (all the registrations for the admins)
+func GetRegistrations(db *gorm.DB, user User) ([]ACMERegistration, error) {
+	registrations := []ACMERegistration{}
+
+	query := db.Order("id")
+	if !user.IsAdmin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	result := query.Find(&registrations)
+	return registrations, result.Error
+}
+
+//DeleteRegistration : delete registration from gorm database (by id)
+func (reg *ACMERegistration) DeleteRegistration(db *gorm.DB) error {
+	result := db.Delete(&reg)
+	return result.Error
+}
+
+//CheckPassword : compare the password with the registration one (constant time)
+func (reg *ACMERegistration) CheckPassword(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(password)), []byte(reg.Hash)) == 1
+}
+
+//Fqdn : name of the challenge records of the registration in the ACME zone
+func (reg *ACMERegistration) Fqdn(d Domain) string {
+	return reg.Subdomain + "." + d.Fqdn
+}
+
+//UpdateChallenge : add the TXT value to the registration records, only the last ACMEChallengeKeep values are kept
+func (reg *ACMERegistration) UpdateChallenge(db *gorm.DB, d Domain, user User, txt string, ttl int) error {
+	record := Record{DomainID: d.ID, Fqdn: reg.Fqdn(d), Type: 16, TTL: ttl, Content: fmt.Sprintf("%q", txt)}
+
+	var existing []Record
+	result := db.Where("domain_id = ? AND fqdn = ? AND type = ?", d.ID, record.Fqdn, record.Type).Order("id").Find(&existing)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	changes := ZoneChanges{Created: []Record{record}}
+	if len(existing) >= ACMEChallengeKeep {
+		changes.Deleted = existing[:len(existing)-ACMEChallengeKeep+1]
+	}
+	return d.ApplyChanges(db, user, "acme.update", changes)
+}
+
+//DeleteChallenges : delete the TXT records of the registration (when it is deleted)
+func (reg *ACMERegistration) DeleteChallenges(db *gorm.DB, d Domain, user User) error {
+	var existing []Record
+	result := db.Where("domain_id = ? AND fqdn = ? AND type = 16", d.ID, reg.Fqdn(d)).Find(&existing)
+	if result.Error != nil || len(existing) == 0 {
+		return result.Error
+	}
+	return d.ApplyChanges(db, user, "acme.delete", ZoneChanges{Deleted: existing})
+}
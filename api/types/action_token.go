@@ -0,0 +1,58 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//Actions of the single-use tokens sent by mail
+const (
+	ActionPasswordReset = "password.reset"
+	ActionEmailVerify   = "email.verify"
+)
+
+//ActionToken : Single-use and time-limited token sent by mail to confirm an action (only its hash is saved)
+type ActionToken struct {
+	ID        int       `gorm:"primaryKey"`
+	UserID    int       `gorm:"not null;index"`
+	Action    string    `gorm:"not null;size:32"`
+	Hash      string    `gorm:"not null;size:64;uniqueIndex"`
+	Email     string    `gorm:"not null;"` //Address the token was sent to
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UsedAt    *time.Time
+}
+
+//CreateActionToken : create the action token in gorm database (the previous ones of the user for the same action are deleted)
+func (t *ActionToken) CreateActionToken(db *gorm.DB, secret string) error {
+	t.Hash = hashSecret(secret)
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND action = ?", t.UserID, t.Action).Delete(&ActionToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&t).Error
+	})
+}
+
+//UseActionToken : get the valid (not used nor expired) action token from gorm database and mark it as used
+func UseActionToken(db *gorm.DB, action string, secret string) (ActionToken, error) {
+	var t ActionToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("hash = ? AND action = ? AND used_at IS NULL AND expires_at > ?", hashSecret(secret), action, time.Now()).First(&t)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		now := time.Now()
+		result = tx.Model(&ActionToken{}).Where("id = ? AND used_at IS NULL", t.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 { //Used by a concurrent request
+			return gorm.ErrRecordNotFound
+		}
+		t.UsedAt = &now
+		return nil
+	})
+	return t, err
+}
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//AuditEntry : Append-only log of an API action (who, what, result, from where, when)
+type AuditEntry struct {
+	ID         int       `gorm:"primaryKey" example:"1"`
+	UserID     int       `example:"2" gorm:"not null;index"`
+	Action     string    `example:"record.delete" gorm:"not null;index"`
+	Method     string    `example:"DELETE" gorm:"not null;"`
+	Endpoint   string    `example:"/api/record/12" gorm:"not null;"`
+	TargetType string    `example:"record" gorm:"not null;"`
+	TargetID   int       `example:"12" gorm:"not null;"`
+	DomainID   int       `example:"1" gorm:"not null;index"`
+	Status     int       `example:"204" gorm:"not null;"`
+	SourceIP   string    `example:"192.0.2.10" gorm:"not null;"`
+	Date       time.Time `gorm:"not null;index"`
+}
+
+//AuditFilter : Criteria to search the audit log (zero values are ignored)
+type AuditFilter struct {
+	UserID   int
+	DomainID int
+	Action   string
+	From     time.Time
+	To       time.Time
+}
+
+//CreateAuditEntry : append the entry to the audit log in gorm database
+//There is no update nor delete, the audit log is append-only
+func (e *AuditEntry) CreateAuditEntry(db *gorm.DB) error {
+	if e.Date.IsZero() {
+		e.Date = time.Now()
+	}
+	result := db.Create(&e)
+	return result.Error
+}
+
+//GetAuditEntries : search the audit log in gorm database (newest first)
+func GetAuditEntries(db *gorm.DB, filter AuditFilter, count int, start int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+
+	query := db.Limit(count).Offset(start).Order("date DESC, id DESC")
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.DomainID != 0 {
+		query = query.Where("domain_id = ?", filter.DomainID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("date >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("date <= ?", filter.To)
+	}
+
+	result := query.Find(&entries)
+	return entries, result.Error
+}
@@ -0,0 +1,118 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ConflictError : Returned when a record can't coexist with the other records of the zone
+type ConflictError struct {
+	Message   string
+	RecordIDs []int //IDs of the conflicting records already in the database
+}
+
+func (e *ConflictError) Error() string {
+	return e.Message
+}
+
+//newConflictError : build a ConflictError naming the conflicting records
+func newConflictError(message string, conflicting []Record) *ConflictError {
+	ids := []int{}
+	for _, r := range conflicting {
+		if r.ID != 0 { //Not created yet
+			ids = append(ids, r.ID)
+		}
+	}
+	return &ConflictError{Message: message, RecordIDs: ids}
+}
+
+//canCoexistWithCNAME : DNSSEC records allowed next to a CNAME (RFC 4035 2.5.)
+func canCoexistWithCNAME(t RRType) bool {
+	return t == 46 || t == 47 //RRSIG, NSEC
+}
+
+//CheckConflicts : check if the record can coexist with the others records of the zone (RFC 1034 3.6.2., RFC 2181 5.)
+//others must not contain the record itself
+func CheckConflicts(apex string, others []Record, r Record) *ConflictError {
+	name := strings.ToLower(r.Fqdn)
+	key := recordKey(r)
+
+	var sameName, cnames, duplicates, ttlMismatches []Record
+	for _, o := range others {
+		if strings.ToLower(o.Fqdn) != name {
+			continue
+		}
+		if r.ID != 0 && o.ID == r.ID {
+			continue
+		}
+		sameName = append(sameName, o)
+		if o.Type == 5 {
+			cnames = append(cnames, o)
+		}
+		if o.Type == r.Type {
+			if recordKey(o) == key {
+				duplicates = append(duplicates, o)
+			} else if o.TTL != r.TTL {
+				ttlMismatches = append(ttlMismatches, o)
+			}
+		}
+	}
+
+	if r.Type == 5 {
+		if name == strings.ToLower(apex) {
+			return newConflictError(fmt.Sprintf("CNAME %s can't be at the zone apex (SOA and NS records are there).", r.Fqdn), sameName)
+		}
+		var blocking []Record
+		for _, o := range sameName {
+			if !canCoexistWithCNAME(o.Type) {
+				blocking = append(blocking, o)
+			}
+		}
+		if len(blocking) > 0 {
+			return newConflictError(fmt.Sprintf("CNAME %s can't coexist with other records at the same name.", r.Fqdn), blocking)
+		}
+	} else if len(cnames) > 0 && !canCoexistWithCNAME(r.Type) {
+		return newConflictError(fmt.Sprintf("%s already has a CNAME, no other record can be added at this name.", r.Fqdn), cnames)
+	}
+
+	if len(duplicates) > 0 {
+		return newConflictError(fmt.Sprintf("%s %s : an identical record already exists.", r.Fqdn, r.Type), duplicates)
+	}
+	if len(ttlMismatches) > 0 {
+		return newConflictError(fmt.Sprintf("%s %s : all the records of the RRset must have the same TTL (RFC 2181 5.2.).", r.Fqdn, r.Type), ttlMismatches)
+	}
+
+	return nil
+}
+
+//CheckChangesConflicts : check the created and updated records against the zone as it will be once the changes are applied
+func CheckChangesConflicts(apex string, existing []Record, changes ZoneChanges) *ConflictError {
+	removed := make(map[int]bool)
+	for _, r := range changes.Deleted {
+		removed[r.ID] = true
+	}
+	for _, r := range changes.Updated {
+		removed[r.ID] = true
+	}
+
+	base := []Record{}
+	for _, r := range existing {
+		if !removed[r.ID] {
+			base = append(base, r)
+		}
+	}
+
+	pending := append(append([]Record{}, changes.Updated...), changes.Created...)
+	for i, r := range pending {
+		others := make([]Record, 0, len(base)+len(pending))
+		others = append(others, base...)
+		others = append(others, pending[:i]...)
+		others = append(others, pending[i+1:]...)
+
+		if conflict := CheckConflicts(apex, others, r); conflict != nil {
+			return conflict
+		}
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/miekg/dns"
 	"gorm.io/gorm"
 )
 
@@ -14,9 +15,12 @@ import (
 type Domain struct {
 	ID          int    `gorm:"primaryKey" example:"1"`
 	OwnerID     int    `example:"2" gorm:"not null;"`
+	TeamID      int    `example:"1" gorm:"not null;default:0;index"` //Team owning the domain (0 if none)
 	Fqdn        string `example:"example.org." gorm:"not null;"`
 	Description string `example:"My example website" gorm:"not null;"`
-	Serial      int    `example:"1" gorm:"not null;"`
+	Serial      int    `example:"2021011801" gorm:"not null;"` //Serial of the SOA (YYYYMMDDnn)
+
+	AllowTransfer Networks `example:"192.0.2.53/32" swaggertype:"array,string" gorm:"not null;default:''"` //Secondaries allowed to transfer the zone without TSIG key
 }
 
 //GetDomain : get all domain infos from gorm database (by id)
@@ -31,13 +35,31 @@ func (d *Domain) GetDomainByFqdn(db *gorm.DB) error {
 	return result.Error
 }
 
-//GetOwner : get domain Owner_ID from gorm database (by id)
+//GetDomainOf : get the domain containing the FQDN (the longest matching domain) from gorm database
+func GetDomainOf(db *gorm.DB, fqdn string) (Domain, error) {
+	name := strings.ToLower(fqdn)
+	for {
+		d := Domain{Fqdn: name}
+		err := d.GetDomainByFqdn(db)
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return d, err
+		}
+
+		i := strings.Index(name, ".")
+		if i < 0 || i == len(name)-1 {
+			return Domain{}, gorm.ErrRecordNotFound
+		}
+		name = name[i+1:]
+	}
+}
+
+//GetOwner : get domain Owner_ID and Team_ID from gorm database (by id)
 func (d *Domain) GetOwner(db *gorm.DB) error {
-	result := db.Select("owner_id").First(&d, d.ID)
+	result := db.Select("owner_id", "team_id").First(&d, d.ID)
 	return result.Error
 }
 
-//GetDomains : get all domains from gorm database (by id)
+//GetDomains : get all domains of the user from gorm database (owned directly, by one of its teams or shared with the user)
 func GetDomains(db *gorm.DB, user User, count int, start int) ([]Domain, error) {
 	domains := []Domain{}
 
@@ -47,7 +69,9 @@ func GetDomains(db *gorm.DB, user User, count int, start int) ([]Domain, error)
 	if user.IsAdmin {
 		rows, err = db.Limit(count).Offset(start).Model(&Domain{}).Rows()
 	} else {
-		rows, err = db.Limit(count).Offset(start).Where("owner_id = ?", user.ID).Model(&Domain{}).Rows()
+		teams := db.Session(&gorm.Session{NewDB: true}).Model(&TeamMember{}).Select("team_id").Where("user_id = ?", user.ID)
+		shared := db.Session(&gorm.Session{NewDB: true}).Model(&DomainMember{}).Select("domain_id").Where("user_id = ?", user.ID)
+		rows, err = db.Limit(count).Offset(start).Where("(owner_id = ? OR team_id IN (?) OR id IN (?))", user.ID, teams, shared).Model(&Domain{}).Rows()
 	}
 	defer rows.Close()
 
@@ -124,28 +148,64 @@ func (d *Domain) Exists(db *gorm.DB) bool {
 	return !errors.Is(result.Error, gorm.ErrRecordNotFound)
 }
 
+//FqdnTaken : check if another domain (with another ID) has the FQDN
+func (d *Domain) FqdnTaken(db *gorm.DB) (bool, error) {
+	var count int64
+	result := db.Model(&Domain{}).Where("fqdn = ? AND id <> ?", d.Fqdn, d.ID).Count(&count)
+	return count > 0, result.Error
+}
+
 //UpdateSOA : Generate SOA automaticly for domain
 // Following Recommandations for DNS SOA Values
 // https://www.ripe.net/publications/docs/ripe-203
-func (d *Domain) UpdateSOA(db *gorm.DB, user User) {
+func (d *Domain) UpdateSOA(db *gorm.DB, user User) error {
 
-	//Email to SOA email recommandations
-	user.Email = strings.ReplaceAll(user.Email, ".", "\\.")
-	user.Email = strings.ReplaceAll(user.Email, "@", ".")
-
-	//Serial incrementation
-	d.Serial = d.Serial + 1
+	//Serial incrementation (RFC 1912 2.2. : YYYYMMDDnn, or the previous serial + 1 after 99 changes in a day)
+	previous, err := zoneSerial(db, d.ID)
+	if err != nil {
+		return err
+	}
+	if d.Serial > 0 && uint32(d.Serial) > previous {
+		previous = uint32(d.Serial)
+	}
+	serial := dateSerial(time.Now())
+	if serial <= previous {
+		serial = previous + 1
+	}
+	d.Serial = int(serial)
 
-	t := time.Now()
-	serial := t.Format("20060102") //RFC 1912 2.2.
-	soa := fmt.Sprintf("master.%s %s %v%v 3600 1800 604800 600", d.Fqdn, user.Email, serial, d.Serial)
-	record := Record{DomainID: d.ID, Fqdn: d.Fqdn, Type: 6, TTL: 3600, Content: soa}
+	record := d.soa(user.Email, serial)
 
 	//Write new record
-	db.Where("type = ? AND fqdn = ?", record.Type, record.Fqdn).Assign(record).FirstOrCreate(&record)
+	result := db.Where("domain_id = ? AND type = ? AND fqdn = ?", d.ID, record.Type, record.Fqdn).Assign(record).FirstOrCreate(&record)
+	if result.Error != nil {
+		return result.Error
+	}
 
 	//Write new serial
-	d.UpdateDomain(db)
+	return d.UpdateDomain(db)
+}
+
+//DefaultSOA : SOA of the domain with its current serial, for the zones without SOA yet
+func (d *Domain) DefaultSOA() Record {
+	return d.soa("", uint32(d.Serial))
+}
+
+//soa : SOA record of the domain (the hostmaster of the domain is the contact if the email is empty or invalid)
+func (d *Domain) soa(email string, serial uint32) Record {
+	//Email to SOA email recommandations (RFC 1035 8. : the dots of the local part are escaped)
+	rname := "hostmaster." + d.Fqdn
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		rname = dns.Fqdn(strings.ReplaceAll(email[:at], ".", "\\.") + "." + email[at+1:])
+	}
+
+	content := fmt.Sprintf("master.%s %s %v 3600 1800 604800 600", d.Fqdn, rname, serial)
+	return Record{DomainID: d.ID, Fqdn: d.Fqdn, Type: 6, TTL: 3600, Content: content}
+}
+
+//dateSerial : first serial of the day (YYYYMMDD00)
+func dateSerial(t time.Time) uint32 {
+	return uint32(t.Year()*1000000 + int(t.Month())*10000 + t.Day()*100)
 }
 
 //GetSOA : get domain SOA from gorm database
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+//DomainMember : User the domain is shared with, and its role on it
+type DomainMember struct {
+	ID       int    `gorm:"primaryKey" example:"1"`
+	DomainID int    `example:"1" gorm:"not null;uniqueIndex:idx_domain_member"`
+	UserID   int    `example:"2" gorm:"not null;uniqueIndex:idx_domain_member;index"`
+	Role     string `example:"editor" gorm:"not null;size:16"`
+}
+
+//ProtectedRecord : check if the record can only be changed by the domain managers (NS and SOA)
+func ProtectedRecord(r Record) bool {
+	return r.Type == 2 || r.Type == 6
+}
+
+//GetMembers : get the users the domain is shared with from gorm database
+func (d *Domain) GetMembers(db *gorm.DB) ([]DomainMember, error) {
+	members := []DomainMember{}
+	result := db.Where("domain_id = ?", d.ID).Order("id").Find(&members)
+	return members, result.Error
+}
+
+//GetMemberRole : get the role of the user on the domain it is shared with (empty if not shared)
+func (d *Domain) GetMemberRole(db *gorm.DB, userID int) (string, error) {
+	var member DomainMember
+	result := db.Where("domain_id = ? AND user_id = ?", d.ID, userID).First(&member)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return member.Role, result.Error
+}
+
+//SetMember : share the domain with the user or change its role
+func (d *Domain) SetMember(db *gorm.DB, userID int, role string) error {
+	member := DomainMember{DomainID: d.ID, UserID: userID}
+	result := db.Where("domain_id = ? AND user_id = ?", d.ID, userID).Assign(DomainMember{Role: role}).FirstOrCreate(&member)
+	return result.Error
+}
+
+//RemoveMember : stop sharing the domain with the user
+func (d *Domain) RemoveMember(db *gorm.DB, userID int) error {
+	result := db.Where("domain_id = ? AND user_id = ?", d.ID, userID).Delete(&DomainMember{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+//DeleteAllMembers : stop sharing the domain with everyone (when the domain is deleted)
+func (d *Domain) DeleteAllMembers(db *gorm.DB) error {
+	result := db.Where("domain_id = ?", d.ID).Delete(&DomainMember{})
+	return result.Error
+}
@@ -0,0 +1,242 @@
+package types
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+//Changeset : Versioned change made to a domain and its records (who, when, before/after)
+type Changeset struct {
+	ID           int            `gorm:"primaryKey" example:"1"`
+	DomainID     int            `example:"1" gorm:"not null;uniqueIndex:idx_changeset_version"`
+	Version      int            `example:"3" gorm:"not null;uniqueIndex:idx_changeset_version"`
+	UserID       int            `example:"2" gorm:"not null;"`
+	Action       string         `example:"record.update" gorm:"not null;"`
+	Date         time.Time      `gorm:"not null;"`
+	Serial       uint32         `example:"2021011803" gorm:"not null;default:0;index"` //SOA serial of the zone once the change is made (0 if the zone has no SOA)
+	Records      []RecordChange `gorm:"-"`
+	DomainBefore *Domain        `gorm:"-"`
+	DomainAfter  *Domain        `gorm:"-"`
+	Content      string         `json:"-" gorm:"type:text;not null;"` //JSON of Records, DomainBefore and DomainAfter
+}
+
+//RecordChange : Record before and after a change (no Before for a creation, no After for a deletion)
+type RecordChange struct {
+	Before *Record
+	After  *Record
+}
+
+//changesetContent : What is stored in the Changeset Content column
+type changesetContent struct {
+	Records      []RecordChange
+	DomainBefore *Domain
+	DomainAfter  *Domain
+}
+
+//CreateChangeset : save the changeset as the next version of the domain
+func (c *Changeset) CreateChangeset(db *gorm.DB) error {
+	content, err := json.Marshal(changesetContent{Records: c.Records, DomainBefore: c.DomainBefore, DomainAfter: c.DomainAfter})
+	if err != nil {
+		return err
+	}
+	c.Content = string(content)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		//The domain row is locked until the end of the transaction : the concurrent changes of the domain wait for their version
+		locking := clause.Locking{Strength: "UPDATE"}
+		if err := tx.Clauses(locking).Select("id").Where("id = ?", c.DomainID).Limit(1).Find(&Domain{}).Error; err != nil {
+			return err
+		}
+		var last Changeset
+		result := tx.Clauses(locking).Select("version").Where("domain_id = ?", c.DomainID).Order("version desc").Limit(1).Find(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+		c.Version = last.Version + 1
+		c.Date = time.Now()
+		if c.Serial, err = zoneSerial(tx, c.DomainID); err != nil {
+			return err
+		}
+
+		return tx.Create(&c).Error
+	})
+}
+
+//zoneSerial : get the serial of the domain SOA (0 if there is none or if it can't be parsed)
+func zoneSerial(db *gorm.DB, domainID int) (uint32, error) {
+	var soa Record
+	result := db.Where("domain_id = ? AND type = 6", domainID).Limit(1).Find(&soa)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, result.Error
+	}
+
+	if rr, err := soa.RR(); err == nil && rr != nil {
+		return rr.(*dns.SOA).Serial, nil
+	}
+	return 0, nil
+}
+
+//decode : fill Records, DomainBefore and DomainAfter from the Content column
+func (c *Changeset) decode() error {
+	var content changesetContent
+	if err := json.Unmarshal([]byte(c.Content), &content); err != nil {
+		return err
+	}
+	c.Records = content.Records
+	c.DomainBefore = content.DomainBefore
+	c.DomainAfter = content.DomainAfter
+	return nil
+}
+
+//LogChanges : save a new version of the domain history
+func (d *Domain) LogChanges(db *gorm.DB, user User, action string, records []RecordChange, before *Domain, after *Domain) error {
+	if records == nil {
+		records = []RecordChange{}
+	}
+	c := Changeset{DomainID: d.ID, UserID: user.ID, Action: action, Records: records, DomainBefore: before, DomainAfter: after}
+	return c.CreateChangeset(db)
+}
+
+//GetHistory : get the domain changesets from gorm database (newest first)
+func (d *Domain) GetHistory(db *gorm.DB, count int, start int) ([]Changeset, error) {
+	changesets := []Changeset{}
+
+	result := db.Limit(count).Offset(start).Where("domain_id = ?", d.ID).Order("version DESC").Find(&changesets)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i := range changesets {
+		if err := changesets[i].decode(); err != nil {
+			return nil, err
+		}
+	}
+	return changesets, nil
+}
+
+//SerialChange : Records deleted and added between two serials of the zone (the SOA excluded)
+type SerialChange struct {
+	From    uint32
+	To      uint32
+	Deleted []Record
+	Added   []Record
+}
+
+//GetSerialChanges : get the changes made to the records since the serial from the domain history (for IXFR)
+//False if the history doesn't cover them (unknown serial, domain deleted or restored), the whole zone is needed
+func (d *Domain) GetSerialChanges(db *gorm.DB, serial uint32) ([]SerialChange, bool, error) {
+	var start Changeset
+	result := db.Where("domain_id = ? AND serial = ?", d.ID, serial).Order("version DESC").Limit(1).Find(&start)
+	if result.Error != nil || result.RowsAffected == 0 || serial == 0 {
+		return nil, false, result.Error
+	}
+
+	changesets := []Changeset{}
+	result = db.Where("domain_id = ? AND version > ?", d.ID, start.Version).Order("version").Find(&changesets)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	changes := []SerialChange{}
+	from := serial
+	for _, c := range changesets {
+		if c.Serial == 0 || c.Action == "domain.delete" || c.Action == "domain.restore" {
+			return nil, false, nil
+		}
+		if err := c.decode(); err != nil {
+			return nil, false, err
+		}
+		if c.Serial == from && len(c.Records) == 0 { //Domain changes only
+			continue
+		}
+
+		change := SerialChange{From: from, To: c.Serial, Deleted: []Record{}, Added: []Record{}}
+		for _, r := range c.Records {
+			if r.Before != nil && r.Before.Type != 6 {
+				change.Deleted = append(change.Deleted, *r.Before)
+			}
+			if r.After != nil && r.After.Type != 6 {
+				change.Added = append(change.Added, *r.After)
+			}
+		}
+		changes = append(changes, change)
+		from = c.Serial
+	}
+	return changes, true, nil
+}
+
+//GetDeletion : get the changeset of the domain deletion (to restore a deleted domain)
+func (d *Domain) GetDeletion(db *gorm.DB) (Changeset, error) {
+	var c Changeset
+	result := db.Where("domain_id = ? AND action = ?", d.ID, "domain.delete").Order("version DESC").First(&c)
+	if result.Error != nil {
+		return c, result.Error
+	}
+	return c, c.decode()
+}
+
+//RollbackChanges : compute the changes needed to restore the domain records as they were at the given version
+//The SOA is ignored as it is generated by UpdateSOA
+func (d *Domain) RollbackChanges(db *gorm.DB, version int) (ZoneChanges, error) {
+	changes := ZoneChanges{Created: []Record{}, Updated: []Record{}, Deleted: []Record{}}
+
+	current, err := d.GetDomainRecords(db, -1, -1)
+	if err != nil {
+		return changes, err
+	}
+
+	//Undo the newer changesets, from the newest to the oldest
+	changesets := []Changeset{}
+	result := db.Where("domain_id = ? AND version > ?", d.ID, version).Order("version DESC").Find(&changesets)
+	if result.Error != nil {
+		return changes, result.Error
+	}
+	for i := range changesets {
+		if err := changesets[i].decode(); err != nil {
+			return changes, err
+		}
+	}
+
+	state := make(map[int]Record, len(current))
+	for _, r := range current {
+		state[r.ID] = r
+	}
+	for _, c := range changesets {
+		for i := len(c.Records) - 1; i >= 0; i-- {
+			change := c.Records[i]
+			if change.Before == nil {
+				delete(state, change.After.ID)
+			} else {
+				state[change.Before.ID] = *change.Before
+			}
+		}
+	}
+
+	//Diff between the current records and the restored ones
+	for _, r := range current {
+		if r.Type == 6 {
+			continue
+		}
+		old, ok := state[r.ID]
+		if !ok {
+			changes.Deleted = append(changes.Deleted, r)
+		} else if old != r {
+			changes.Updated = append(changes.Updated, old)
+		}
+		delete(state, r.ID)
+	}
+	for _, r := range state {
+		if r.Type != 6 {
+			r.DomainID = d.ID
+			changes.Created = append(changes.Created, r) //Restored with its original ID
+		}
+	}
+	sort.Slice(changes.Created, func(i, j int) bool { return changes.Created[i].ID < changes.Created[j].ID })
+
+	return changes, nil
+}
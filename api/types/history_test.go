@@ -0,0 +1,230 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+//testDB : migrated in-memory database
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("can't open the database : %s", err)
+	}
+	SQLMigrate(db)
+	return db
+}
+
+//historyDomain : domain with the versions
+//1 : www 192.0.2.1 and mail 192.0.2.2 created
+//2 : www updated to 192.0.2.3
+//3 : mail deleted, ftp 192.0.2.4 created
+//4 : domain changes only (same serial)
+func historyDomain(t *testing.T, db *gorm.DB) (Domain, User, []uint32) {
+	user := User{Email: "admin@example.org", Username: "admin", IsAdmin: true}
+	if err := user.CreateUser(db); err != nil {
+		t.Fatalf("can't create the user : %s", err)
+	}
+	d := Domain{OwnerID: user.ID, Fqdn: "example.org."}
+	if err := d.CreateDomain(db); err != nil {
+		t.Fatalf("can't create the domain : %s", err)
+	}
+
+	record := func(fqdn string) Record {
+		var r Record
+		if err := db.Where("domain_id = ? AND fqdn = ?", d.ID, fqdn).First(&r).Error; err != nil {
+			t.Fatalf("can't get the record %s : %s", fqdn, err)
+		}
+		return r
+	}
+	apply := func(changes ZoneChanges) {
+		if err := d.ApplyChanges(db, user, "zone.import", changes); err != nil {
+			t.Fatalf("can't apply the changes : %s", err)
+		}
+	}
+
+	apply(ZoneChanges{Created: []Record{
+		{DomainID: d.ID, Fqdn: "www.example.org.", Type: 1, TTL: 300, Content: "192.0.2.1"},
+		{DomainID: d.ID, Fqdn: "mail.example.org.", Type: 1, TTL: 300, Content: "192.0.2.2"},
+	}})
+	www := record("www.example.org.")
+	www.Content = "192.0.2.3"
+	apply(ZoneChanges{Updated: []Record{www}})
+	apply(ZoneChanges{
+		Deleted: []Record{record("mail.example.org.")},
+		Created: []Record{{DomainID: d.ID, Fqdn: "ftp.example.org.", Type: 1, TTL: 300, Content: "192.0.2.4"}},
+	})
+	before, after := d, d
+	after.Description = "hello"
+	if err := d.LogChanges(db, user, "domain.update", nil, &before, &after); err != nil {
+		t.Fatalf("can't log the domain changes : %s", err)
+	}
+
+	history, err := d.GetHistory(db, -1, -1)
+	if err != nil || len(history) != 4 {
+		t.Fatalf("got %d changesets (%v), want 4", len(history), err)
+	}
+	serials := []uint32{}
+	for i := len(history) - 1; i >= 0; i-- {
+		serials = append(serials, history[i].Serial)
+	}
+	return d, user, serials
+}
+
+//contents : sorted "fqdn content" of the records
+func contents(records []Record) []string {
+	keys := []string{}
+	for _, r := range records {
+		keys = append(keys, r.Fqdn+" "+r.Content)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRollbackChanges(t *testing.T) {
+	db := testDB(t)
+	d, _, _ := historyDomain(t, db)
+
+	tests := []struct {
+		version int
+		created []string
+		updated []string
+		deleted []string
+	}{
+		{4, []string{}, []string{}, []string{}},
+		{3, []string{}, []string{}, []string{}},
+		{2, []string{"mail.example.org. 192.0.2.2"}, []string{}, []string{"ftp.example.org. 192.0.2.4"}},
+		{1, []string{"mail.example.org. 192.0.2.2"}, []string{"www.example.org. 192.0.2.1"}, []string{"ftp.example.org. 192.0.2.4"}},
+		{0, []string{}, []string{}, []string{"ftp.example.org. 192.0.2.4", "www.example.org. 192.0.2.3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("version %d", test.version), func(t *testing.T) {
+			changes, err := d.RollbackChanges(db, test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := [][]string{contents(changes.Created), contents(changes.Updated), contents(changes.Deleted)}
+			want := [][]string{test.created, test.updated, test.deleted}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	//The deleted records are restored with their ID
+	changes, _ := d.RollbackChanges(db, 2)
+	if len(changes.Created) != 1 || changes.Created[0].ID != 2 {
+		t.Errorf("got %+v, want the record 2", changes.Created)
+	}
+}
+
+func TestGetSerialChanges(t *testing.T) {
+	db := testDB(t)
+	d, user, serials := historyDomain(t, db)
+	if serials[0] == 0 || serials[0] >= serials[1] || serials[1] >= serials[2] || serials[2] != serials[3] {
+		t.Fatalf("unexpected serials %v", serials)
+	}
+
+	//"s1>s2 -deleted +added" for each change
+	format := func(changes []SerialChange) []string {
+		names := map[uint32]string{}
+		for i, serial := range serials {
+			if _, ok := names[serial]; !ok {
+				names[serial] = fmt.Sprintf("s%d", i+1)
+			}
+		}
+		lines := []string{}
+		for _, c := range changes {
+			lines = append(lines, fmt.Sprintf("%s>%s -%s +%s", names[c.From], names[c.To], strings.Join(contents(c.Deleted), ","), strings.Join(contents(c.Added), ",")))
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name    string
+		serial  uint32
+		ok      bool
+		changes []string
+	}{
+		{"first version", serials[0], true, []string{
+			"s1>s2 -www.example.org. 192.0.2.1 +www.example.org. 192.0.2.3",
+			"s2>s3 -mail.example.org. 192.0.2.2 +ftp.example.org. 192.0.2.4",
+		}},
+		{"second version", serials[1], true, []string{"s2>s3 -mail.example.org. 192.0.2.2 +ftp.example.org. 192.0.2.4"}},
+		{"current serial (domain changes only since)", serials[2], true, []string{}},
+		{"unknown serial", serials[0] - 1, false, nil},
+		{"no serial", 0, false, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, ok, err := d.GetSerialChanges(db, test.serial)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.ok {
+				t.Fatalf("got %v, want %v", ok, test.ok)
+			}
+			if got := format(changes); ok && !reflect.DeepEqual(got, test.changes) {
+				t.Errorf("got %q, want %q", got, test.changes)
+			}
+		})
+	}
+
+	//The history doesn't cover the changes across a deletion
+	before := d
+	if err := d.LogChanges(db, user, "domain.delete", nil, &before, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := d.GetSerialChanges(db, serials[0]); ok || err != nil {
+		t.Errorf("got %v (%v) after the deletion, want false", ok, err)
+	}
+}
+
+func TestDiffZone(t *testing.T) {
+	existing := []Record{
+		{ID: 1, Fqdn: "example.org.", Type: 6, TTL: 3600, Content: "master.example.org. hostmaster.example.org. 1 3600 1800 604800 600"},
+		{ID: 2, Fqdn: "www.example.org.", Type: 1, TTL: 300, Content: "192.0.2.1"},
+		{ID: 3, Fqdn: "www.example.org.", Type: 28, TTL: 300, Content: "2001:db8::1"},
+		{ID: 4, Fqdn: "mail.example.org.", Type: 1, TTL: 300, Content: "192.0.2.2"},
+	}
+	wanted := []Record{
+		{Fqdn: "WWW.example.org.", Type: 1, TTL: 600, Content: "192.0.2.1"},
+		{Fqdn: "www.example.org.", Type: 28, TTL: 300, Content: "2001:DB8:0::1"},
+		{Fqdn: "ftp.example.org.", Type: 1, TTL: 300, Content: "192.0.2.3"},
+		{Fqdn: "ftp.example.org.", Type: 1, TTL: 300, Content: "192.0.2.3"},
+	}
+
+	tests := []struct {
+		name    string
+		replace bool
+		created []string
+		updated []string
+		deleted []string
+	}{
+		{"merge", false, []string{"ftp.example.org. 192.0.2.3"}, []string{"www.example.org. 192.0.2.1"}, []string{}},
+		{"replace", true, []string{"ftp.example.org. 192.0.2.3"}, []string{"www.example.org. 192.0.2.1"}, []string{"mail.example.org. 192.0.2.2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := DiffZone(existing, wanted, test.replace)
+			got := [][]string{contents(changes.Created), contents(changes.Updated), contents(changes.Deleted)}
+			want := [][]string{test.created, test.updated, test.deleted}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+			if len(changes.Updated) == 1 && (changes.Updated[0].ID != 2 || changes.Updated[0].TTL != 600) {
+				t.Errorf("got %+v, want the record 2 with the TTL 600", changes.Updated[0])
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package types
 
 import (
+	"strings"
+
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -11,4 +13,99 @@ func SQLMigrate(db *gorm.DB) {
 	db.AutoMigrate(&Domain{})
 	db.AutoMigrate(&Record{})
 	db.AutoMigrate(&User{})
+	db.AutoMigrate(&Changeset{})
+	db.AutoMigrate(&AuditEntry{})
+	if err := hashPlainTokens(db); err != nil {
+		logrus.Fatalf("SQL : Can't hash the tokens secrets (migration stopped, the tokens are kept) : %s", err)
+	}
+	db.AutoMigrate(&Token{})
+	db.AutoMigrate(&Session{})
+	db.AutoMigrate(&RecoveryCode{})
+	db.AutoMigrate(&LoginFailure{})
+	db.AutoMigrate(&LoginLock{})
+	db.AutoMigrate(&ActionToken{})
+	db.AutoMigrate(&Team{})
+	db.AutoMigrate(&TeamMember{})
+	db.AutoMigrate(&DomainMember{})
+	db.AutoMigrate(&RecordRule{})
+	db.AutoMigrate(&ACMERegistration{})
+	db.AutoMigrate(&TSIGKey{})
+	db.AutoMigrate(&TSIGKeyDomain{})
+	migrateUsersTokens(db)
+}
+
+//migrateUsersTokens : move the single token of each user (users.token column) to the tokens table
+func migrateUsersTokens(db *gorm.DB) {
+	if !db.Migrator().HasColumn(&User{}, "token") {
+		return
+	}
+	logrus.Info("SQL : Moving users tokens to the tokens table")
+
+	var users []struct {
+		ID      int
+		Token   string
+		IsAdmin bool
+	}
+	result := db.Table("users").Select("id", "token", "is_admin").Scan(&users)
+	if result.Error != nil {
+		logrus.Errorf("SQL : Can't read users tokens : %s", result.Error)
+		return
+	}
+
+	for _, u := range users {
+		if u.Token == "" {
+			continue
+		}
+		token := Token{UserID: u.ID, Name: "default", Secret: u.Token, Scopes: User{IsAdmin: u.IsAdmin}.DefaultScopes()}
+		if err := token.CreateToken(db); err != nil {
+			logrus.Errorf("SQL : Can't move token of user %v : %s", u.ID, err)
+			return
+		}
+	}
+
+	if err := db.Migrator().DropColumn(&User{}, "token"); err != nil {
+		logrus.Errorf("SQL : Can't drop users token column : %s", err)
+	}
+}
+
+//hashPlainTokens : hash in place the tokens saved with their plain secret (tokens.secret column), in a single transaction
+//The secret column is dropped last : if the migration stops, it can be launched again
+func hashPlainTokens(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Token{}) || !db.Migrator().HasColumn(&Token{}, "secret") {
+		return nil
+	}
+	logrus.Info("SQL : Hashing the tokens secrets")
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		//Filled below, the indexes are created by the migration of the table
+		for _, column := range []string{"prefix VARCHAR(16)", "hash VARCHAR(64)"} {
+			name := strings.Fields(column)[0]
+			if tx.Migrator().HasColumn(&Token{}, name) {
+				continue
+			}
+			if err := tx.Exec("ALTER TABLE tokens ADD COLUMN " + column + " NOT NULL DEFAULT ''").Error; err != nil {
+				return err
+			}
+		}
+
+		var rows []struct {
+			ID     int
+			Secret string
+		}
+		if err := tx.Table("tokens").Select("id", "secret").Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, row := range rows {
+			prefix := row.Secret
+			if len(prefix) > TokenPrefixLength {
+				prefix = prefix[:TokenPrefixLength]
+			}
+			result := tx.Table("tokens").Where("id = ?", row.ID).Updates(map[string]interface{}{"prefix": prefix, "hash": hashSecret(row.Secret)})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+
+		return tx.Migrator().DropColumn(&Token{}, "secret")
+	})
 }
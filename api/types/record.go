@@ -1,23 +1,34 @@
 package types
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 
+	"github.com/miekg/dns"
 	"gorm.io/gorm"
 )
 
 //Record : Struct for a domain record
-//Defined by it's ID, DomainID (parent domain), Fqdn (or name), Content (value of the record), Type (as Qtype/int), TTL
+//Defined by it's ID, DomainID (parent domain), Fqdn (or name), Content (value of the record), Type (as Qtype/int, mnemonic or number in JSON), TTL
 type Record struct {
 	ID       int    `gorm:"primaryKey;not null"`
 	DomainID int    `example:"1" gorm:"not null;"`
 	Fqdn     string `example:"sub.example.org." gorm:"not null;"`
 	Content  string `example:"192.0.2.3" gorm:"not null;"`
-	Type     int    `example:"1" gorm:"not null;"`
-	Qtype    uint16 `gorm:"-"` //Not saved in the database
+	Type     RRType `example:"1" swaggertype:"integer" gorm:"not null;"`
+	TypeName string `example:"A" readonly:"true" gorm:"-"` //Mnemonic of the type, only written in JSON
+	Qtype    uint16 `json:"-" gorm:"-"`                    //Not saved in the database, used by sacrebleu-dns queries
 	TTL      int    `example:"3600" gorm:"not null;"`
 }
 
+//MarshalJSON : write the record with the mnemonic of its type in TypeName
+func (r Record) MarshalJSON() ([]byte, error) {
+	type record Record //Without the MarshalJSON method
+	r.TypeName = r.Type.String()
+	return json.Marshal(record(r))
+}
+
 //GetRecord : get record from gorm database (by id)
 func (r *Record) GetRecord(db *gorm.DB) error {
 	result := db.First(&r, r.ID)
@@ -47,3 +58,13 @@ func (r *Record) Exists(db *gorm.DB) bool {
 	result := db.Where("fqdn = ?", r.Fqdn).First(&r)
 	return !errors.Is(result.Error, gorm.ErrRecordNotFound)
 }
+
+//RR : convert the record to a miekg/dns resource record (same way sacrebleu-dns does)
+//The types unknown to miekg/dns are written in their RFC 3597 form (TYPE65280)
+func (r *Record) RR() (dns.RR, error) {
+	typeName, ok := dns.TypeToString[uint16(r.Type)]
+	if !ok {
+		typeName = fmt.Sprintf("TYPE%v", uint16(r.Type))
+	}
+	return dns.NewRR(fmt.Sprintf("%s %v IN %s %s", r.Fqdn, r.TTL, typeName, r.Content))
+}
@@ -0,0 +1,200 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//RRType : Record type (Qtype), read as its mnemonic (eg : "AAAA") or its number in JSON, written as its number
+//Stored as an integer in the database
+type RRType int
+
+//rrTypes : IANA DNS resource record types registry
+//https://www.iana.org/assignments/dns-parameters/dns-parameters.xhtml#dns-parameters-4
+var rrTypes = map[RRType]string{
+	1:     "A",
+	2:     "NS",
+	3:     "MD",
+	4:     "MF",
+	5:     "CNAME",
+	6:     "SOA",
+	7:     "MB",
+	8:     "MG",
+	9:     "MR",
+	10:    "NULL",
+	11:    "WKS",
+	12:    "PTR",
+	13:    "HINFO",
+	14:    "MINFO",
+	15:    "MX",
+	16:    "TXT",
+	17:    "RP",
+	18:    "AFSDB",
+	19:    "X25",
+	20:    "ISDN",
+	21:    "RT",
+	22:    "NSAP",
+	23:    "NSAP-PTR",
+	24:    "SIG",
+	25:    "KEY",
+	26:    "PX",
+	27:    "GPOS",
+	28:    "AAAA",
+	29:    "LOC",
+	30:    "NXT",
+	31:    "EID",
+	32:    "NIMLOC",
+	33:    "SRV",
+	34:    "ATMA",
+	35:    "NAPTR",
+	36:    "KX",
+	37:    "CERT",
+	38:    "A6",
+	39:    "DNAME",
+	40:    "SINK",
+	41:    "OPT",
+	42:    "APL",
+	43:    "DS",
+	44:    "SSHFP",
+	45:    "IPSECKEY",
+	46:    "RRSIG",
+	47:    "NSEC",
+	48:    "DNSKEY",
+	49:    "DHCID",
+	50:    "NSEC3",
+	51:    "NSEC3PARAM",
+	52:    "TLSA",
+	53:    "SMIMEA",
+	55:    "HIP",
+	56:    "NINFO",
+	57:    "RKEY",
+	58:    "TALINK",
+	59:    "CDS",
+	60:    "CDNSKEY",
+	61:    "OPENPGPKEY",
+	62:    "CSYNC",
+	63:    "ZONEMD",
+	64:    "SVCB",
+	65:    "HTTPS",
+	66:    "DSYNC",
+	67:    "HHIT",
+	68:    "BRID",
+	99:    "SPF",
+	100:   "UINFO",
+	101:   "UID",
+	102:   "GID",
+	103:   "UNSPEC",
+	104:   "NID",
+	105:   "L32",
+	106:   "L64",
+	107:   "LP",
+	108:   "EUI48",
+	109:   "EUI64",
+	128:   "NXNAME",
+	249:   "TKEY",
+	250:   "TSIG",
+	251:   "IXFR",
+	252:   "AXFR",
+	253:   "MAILB",
+	254:   "MAILA",
+	255:   "*",
+	256:   "URI",
+	257:   "CAA",
+	258:   "AVC",
+	259:   "DOA",
+	260:   "AMTRELAY",
+	261:   "RESINFO",
+	262:   "WALLET",
+	263:   "CLA",
+	264:   "IPN",
+	32768: "TA",
+	32769: "DLV",
+}
+
+//metaTypes : types only meaningful in queries or messages, they can't be stored as records
+var metaTypes = map[RRType]bool{41: true, 128: true, 249: true, 250: true, 251: true, 252: true, 253: true, 254: true, 255: true}
+
+//rrTypesByName : reverse of rrTypes
+var rrTypesByName = make(map[string]RRType, len(rrTypes))
+
+func init() {
+	for t, name := range rrTypes {
+		rrTypesByName[name] = t
+	}
+}
+
+//RRTypes : get the registry as a mnemonic => number map
+func RRTypes() map[string]int {
+	types := make(map[string]int, len(rrTypes))
+	for t, name := range rrTypes {
+		types[name] = int(t)
+	}
+	return types
+}
+
+//UnknownTypeError : Returned when a record type mnemonic or number isn't known
+type UnknownTypeError struct {
+	Type string
+}
+
+func (e *UnknownTypeError) Error() string {
+	return fmt.Sprintf("unknown record type %s", e.Type)
+}
+
+//ParseRRType : get a record type from its mnemonic (case insensitive), its RFC 3597 form (TYPE65280) or its number
+func ParseRRType(s string) (RRType, error) {
+	name := strings.ToUpper(strings.TrimSpace(s))
+	if t, ok := rrTypesByName[name]; ok {
+		return t, nil
+	}
+
+	n, err := strconv.ParseUint(strings.TrimPrefix(name, "TYPE"), 10, 16)
+	if err != nil || n == 0 {
+		return 0, &UnknownTypeError{Type: s}
+	}
+	return RRType(n), nil
+}
+
+//String : mnemonic of the type, or its RFC 3597 form if it isn't in the registry
+func (t RRType) String() string {
+	if name, ok := rrTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%v", int(t))
+}
+
+//Known : check if the type is in the IANA registry
+func (t RRType) Known() bool {
+	_, ok := rrTypes[t]
+	return ok
+}
+
+//Meta : check if the type is a meta type or a query type (can't be stored)
+func (t RRType) Meta() bool {
+	return metaTypes[t]
+}
+
+//UnmarshalJSON : read the type from its mnemonic or its number
+func (t *RRType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		parsed, err := ParseRRType(name)
+		if err != nil {
+			return err
+		}
+		*t = parsed
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return &UnknownTypeError{Type: string(data)}
+	}
+	if n < 1 || n > 65535 {
+		return &UnknownTypeError{Type: string(data)}
+	}
+	*t = RRType(n)
+	return nil
+}
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRecordTypeJSON(t *testing.T) {
+	for _, input := range []string{`{"Type":"AAAA"}`, `{"Type":"aaaa"}`, `{"Type":28}`, `{"Type":"TYPE28"}`} {
+		var r Record
+		if err := json.Unmarshal([]byte(input), &r); err != nil || r.Type != 28 {
+			t.Errorf("%s : got %v (%v), want 28", input, r.Type, err)
+		}
+	}
+	for _, input := range []string{`{"Type":"FOO"}`, `{"Type":0}`, `{"Type":65536}`} {
+		var r Record
+		if err := json.Unmarshal([]byte(input), &r); err == nil {
+			t.Errorf("%s : accepted as %v", input, r.Type)
+		}
+	}
+
+	data, err := json.Marshal(Record{Type: 28})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); !strings.Contains(s, `"Type":28`) || !strings.Contains(s, `"TypeName":"AAAA"`) {
+		t.Errorf("got %s", s)
+	}
+	if data, _ := json.Marshal(Record{Type: 65280}); !strings.Contains(string(data), `"TypeName":"TYPE65280"`) {
+		t.Errorf("got %s", data)
+	}
+}
@@ -0,0 +1,251 @@
+package types
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+//Record actions allowed by the permission rules
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+//RRTypeList : List of record types, stored space separated (mnemonics) in the database
+type RRTypeList []RRType
+
+//Value : write the types in the database
+func (l RRTypeList) Value() (driver.Value, error) {
+	names := make([]string, len(l))
+	for i, t := range l {
+		names[i] = t.String()
+	}
+	return strings.Join(names, " "), nil
+}
+
+//Scan : read the types from the database
+func (l *RRTypeList) Scan(value interface{}) error {
+	var names Scopes
+	if err := names.Scan(value); err != nil {
+		return err
+	}
+
+	*l = make(RRTypeList, len(names))
+	for i, name := range names {
+		t, err := ParseRRType(name)
+		if err != nil {
+			return err
+		}
+		(*l)[i] = t
+	}
+	return nil
+}
+
+//GormDataType : types are stored as a string
+func (RRTypeList) GormDataType() string {
+	return "string"
+}
+
+//Actions : List of record actions, stored space separated in the database
+type Actions []string
+
+//Value : write the actions in the database
+func (a Actions) Value() (driver.Value, error) {
+	return Scopes(a).Value()
+}
+
+//Scan : read the actions from the database
+func (a *Actions) Scan(value interface{}) error {
+	return (*Scopes)(a).Scan(value)
+}
+
+//GormDataType : actions are stored as a string
+func (Actions) GormDataType() string {
+	return "string"
+}
+
+//RecordRule : Permission for a user or a token to change some records of a domain it has no editor role on
+//The records must match the FQDN glob (path.Match syntax, eg : *.app.example.org.) and the types (all but NS and SOA if empty)
+//Actions are create, update and delete (all if empty)
+type RecordRule struct {
+	ID       int        `gorm:"primaryKey" example:"1"`
+	UserID   int        `example:"2" gorm:"not null;default:0;index"` //Subject user (0 if the rule is for a token)
+	TokenID  int        `example:"0" gorm:"not null;default:0;index"` //Subject token (0 if the rule is for a user)
+	DomainID int        `example:"1" gorm:"not null;index"`
+	Pattern  string     `example:"*.app.example.org." gorm:"not null;size:255"`
+	Types    RRTypeList `example:"16,5" swaggertype:"array,integer" gorm:"not null;"`
+	Actions  Actions    `example:"create,update,delete" swaggertype:"array,string" gorm:"not null;"`
+}
+
+//RuleFilter : Criteria to search the permission rules (zero values are ignored)
+type RuleFilter struct {
+	UserID   int
+	TokenID  int
+	DomainID int
+}
+
+//Validate : check the subject, the pattern, the types and the actions of the rule (the domain FQDN is the pattern end)
+func (rule *RecordRule) Validate(d Domain) error {
+	if (rule.UserID == 0) == (rule.TokenID == 0) {
+		return errors.New("the rule needs a subject : UserID or TokenID")
+	}
+
+	rule.Pattern = strings.ToLower(strings.TrimSpace(rule.Pattern))
+	if !strings.HasSuffix(rule.Pattern, ".") {
+		return errors.New("the pattern must be a fully qualified name (ending with a dot)")
+	}
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern : %s", err)
+	}
+	if !strings.HasSuffix(rule.Pattern, strings.ToLower(d.Fqdn)) {
+		return fmt.Errorf("the pattern must be in the domain %s", d.Fqdn)
+	}
+
+	for _, t := range rule.Types {
+		if ProtectedRecord(Record{Type: t}) {
+			return errors.New("the NS and SOA records can't be given by a rule")
+		}
+		if t.Meta() {
+			return fmt.Errorf("%s is not a record type", t)
+		}
+	}
+	for _, action := range rule.Actions {
+		if action != ActionCreate && action != ActionUpdate && action != ActionDelete {
+			return fmt.Errorf("unknown action %q (create, update or delete)", action)
+		}
+	}
+	return nil
+}
+
+//Matches : check if the record matches the pattern and the types of the rule (whatever the action)
+func (rule RecordRule) Matches(record Record) bool {
+	if ProtectedRecord(record) {
+		return false
+	}
+	if matched, _ := path.Match(rule.Pattern, strings.ToLower(record.Fqdn)); !matched {
+		return false
+	}
+
+	if len(rule.Types) > 0 {
+		allowed := false
+		for _, t := range rule.Types {
+			allowed = allowed || t == record.Type
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+//Allows : check if the rule allows the action on the record
+func (rule RecordRule) Allows(action string, record Record) bool {
+	if !rule.Matches(record) {
+		return false
+	}
+
+	if len(rule.Actions) > 0 {
+		allowed := false
+		for _, a := range rule.Actions {
+			allowed = allowed || a == action
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+//RulesAllow : check if one of the rules allows the action on the record
+func RulesAllow(rules []RecordRule, action string, record Record) bool {
+	for _, rule := range rules {
+		if rule.Allows(action, record) {
+			return true
+		}
+	}
+	return false
+}
+
+//RulesMatch : check if one of the rules matches the record (the subject can read it)
+func RulesMatch(rules []RecordRule, record Record) bool {
+	for _, rule := range rules {
+		if rule.Matches(record) {
+			return true
+		}
+	}
+	return false
+}
+
+//GetRule : get rule from gorm database (by id)
+func (rule *RecordRule) GetRule(db *gorm.DB) error {
+	result := db.First(&rule, rule.ID)
+	return result.Error
+}
+
+//GetRules : search the permission rules in gorm database
+func GetRules(db *gorm.DB, filter RuleFilter, count int, start int) ([]RecordRule, error) {
+	rules := []RecordRule{}
+
+	query := db.Limit(count).Offset(start).Order("id")
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.TokenID != 0 {
+		query = query.Where("token_id = ?", filter.TokenID)
+	}
+	if filter.DomainID != 0 {
+		query = query.Where("domain_id = ?", filter.DomainID)
+	}
+
+	result := query.Find(&rules)
+	return rules, result.Error
+}
+
+//GetSubjectRules : get the rules of the user or of its token on the domain from gorm database
+func GetSubjectRules(db *gorm.DB, domainID int, userID int, tokenID int) ([]RecordRule, error) {
+	rules := []RecordRule{}
+	query := db.Where("domain_id = ?", domainID)
+	if tokenID != 0 {
+		query = query.Where("(user_id = ? OR token_id = ?)", userID, tokenID)
+	} else {
+		query = query.Where("user_id = ?", userID)
+	}
+	result := query.Find(&rules)
+	return rules, result.Error
+}
+
+//CreateRule : create rule in gorm database
+func (rule *RecordRule) CreateRule(db *gorm.DB) error {
+	result := db.Create(&rule)
+	return result.Error
+}
+
+//UpdateRule : update rule in gorm database (by id)
+func (rule *RecordRule) UpdateRule(db *gorm.DB) error {
+	result := db.Save(&rule)
+	return result.Error
+}
+
+//DeleteRule : delete rule from gorm database (by id)
+func (rule *RecordRule) DeleteRule(db *gorm.DB) error {
+	result := db.Delete(&rule)
+	return result.Error
+}
+
+//DeleteDomainRules : delete the rules of the domain from gorm database (when the domain is deleted)
+func (d *Domain) DeleteDomainRules(db *gorm.DB) error {
+	result := db.Where("domain_id = ?", d.ID).Delete(&RecordRule{})
+	return result.Error
+}
+
+//DeleteSubjectRules : delete the rules of the user or of the token from gorm database (when it is deleted)
+func DeleteSubjectRules(db *gorm.DB, userID int, tokenID int) error {
+	result := db.Where("(user_id = ? AND user_id <> 0) OR (token_id = ? AND token_id <> 0)", userID, tokenID).Delete(&RecordRule{})
+	return result.Error
+}
@@ -0,0 +1,164 @@
+package types
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//ErrSessionReused : the refresh token was already rotated (replayed or used concurrently)
+var ErrSessionReused = errors.New("refresh token already used")
+
+//Session : Login session of a user, kept alive with its refresh token
+type Session struct {
+	ID         int       `gorm:"primaryKey" example:"1"`
+	UserID     int       `example:"2" gorm:"not null;index"`
+	Prefix     string    `example:"Xk3v9QaL" gorm:"not null;size:16;index"`
+	Hash       string    `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	PrevHash   string    `json:"-" gorm:"size:64;index"` //Hash of the replaced refresh token (to detect its reuse)
+	Secret     string    `json:"-" gorm:"-"`             //Refresh token, only known when created or rotated
+	Scopes     Scopes    `example:"write" swaggertype:"array,string" gorm:"not null;"`
+	ExpiresAt  time.Time `gorm:"index"`
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+}
+
+//setSecret : set the refresh token of the session (with its prefix and hash)
+func (s *Session) setSecret(secret string) error {
+	if len(secret) <= TokenPrefixLength {
+		return errors.New("refresh token too short")
+	}
+	s.Secret = secret
+	s.Prefix = secret[:TokenPrefixLength]
+	s.Hash = hashSecret(secret)
+	return nil
+}
+
+//Expired : check if the session expiration date is passed
+func (s Session) Expired() bool {
+	return s.ExpiresAt.Before(time.Now())
+}
+
+//Revoked : check if the session was revoked (logout)
+func (s Session) Revoked() bool {
+	return s.RevokedAt != nil
+}
+
+//CreateSession : create session in gorm database
+func (s *Session) CreateSession(db *gorm.DB, secret string) error {
+	if err := s.setSecret(secret); err != nil {
+		return err
+	}
+	result := db.Create(&s)
+	return result.Error
+}
+
+//GetSessionBySecret : get session from gorm database (by refresh token, using its prefix and hash)
+func (s *Session) GetSessionBySecret(db *gorm.DB) error {
+	if len(s.Secret) <= TokenPrefixLength {
+		return gorm.ErrRecordNotFound
+	}
+
+	var sessions []Session
+	result := db.Where("prefix = ?", s.Secret[:TokenPrefixLength]).Find(&sessions)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	hash := hashSecret(s.Secret)
+	for _, session := range sessions {
+		if subtle.ConstantTimeCompare([]byte(session.Hash), []byte(hash)) == 1 {
+			session.Secret = s.Secret
+			*s = session
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+//GetSessionByPrevSecret : get session from gorm database (by its replaced refresh token)
+func (s *Session) GetSessionByPrevSecret(db *gorm.DB) error {
+	if s.Secret == "" {
+		return gorm.ErrRecordNotFound
+	}
+	result := db.Where("prev_hash = ?", hashSecret(s.Secret)).First(&s)
+	return result.Error
+}
+
+//Rotate : replace the refresh token of the session (the previous one can't be used anymore)
+//If the refresh token was already replaced (concurrent use), the session is revoked and ErrSessionReused returned
+func (s *Session) Rotate(db *gorm.DB, secret string, expiresAt time.Time) error {
+	previous := s.Hash
+	if err := s.setSecret(secret); err != nil {
+		return err
+	}
+	now := time.Now()
+	s.LastUsedAt = &now
+	s.ExpiresAt = expiresAt
+	result := db.Model(&Session{}).Where("id = ? AND hash = ? AND revoked_at IS NULL", s.ID, previous).Updates(map[string]interface{}{
+		"prefix":       s.Prefix,
+		"hash":         s.Hash,
+		"prev_hash":    previous,
+		"scopes":       s.Scopes,
+		"expires_at":   s.ExpiresAt,
+		"last_used_at": now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		if err := s.Revoke(db); err != nil {
+			return err
+		}
+		return ErrSessionReused
+	}
+	return nil
+}
+
+//Revoke : revoke the session (logout)
+func (s *Session) Revoke(db *gorm.DB) error {
+	now := time.Now()
+	s.RevokedAt = &now
+	result := db.Model(&Session{}).Where("id = ?", s.ID).Update("revoked_at", now)
+	return result.Error
+}
+
+//GetSession : get session from gorm database (by id)
+func (s *Session) GetSession(db *gorm.DB) error {
+	result := db.First(&s, s.ID)
+	return result.Error
+}
+
+//GetRevokedSessions : get the sessions revoked after the date
+func GetRevokedSessions(db *gorm.DB, since time.Time) ([]Session, error) {
+	sessions := []Session{}
+	result := db.Where("revoked_at > ?", since).Find(&sessions)
+	return sessions, result.Error
+}
+
+//RevokeSessions : revoke all the active sessions of a user, and return them
+func (u *User) RevokeSessions(db *gorm.DB) ([]Session, error) {
+	sessions := []Session{}
+	result := db.Where("user_id = ? AND revoked_at IS NULL", u.ID).Find(&sessions)
+	if result.Error != nil || len(sessions) == 0 {
+		return sessions, result.Error
+	}
+
+	now := time.Now()
+	ids := make([]int, len(sessions))
+	for i := range sessions {
+		ids[i] = sessions[i].ID
+		sessions[i].RevokedAt = &now
+	}
+	result = db.Model(&Session{}).Where("id IN ?", ids).Update("revoked_at", now)
+	return sessions, result.Error
+}
+
+//DeleteExpiredSessions : delete the expired sessions of a user from gorm database
+func (u *User) DeleteExpiredSessions(db *gorm.DB) error {
+	result := db.Where("user_id = ? AND expires_at < ?", u.ID, time.Now()).Delete(&Session{})
+	return result.Error
+}
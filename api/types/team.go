@@ -0,0 +1,238 @@
+package types
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+//Roles on the domains :
+// viewer : read the domain and its records
+// editor : write the records (except NS and SOA)
+// manager : editor who can also write NS and SOA records and share the domain (domain members only)
+// owner : manager who can also delete the domain and give it to another user or team (domain owner, team owners)
+const (
+	RoleViewer  = "viewer"
+	RoleEditor  = "editor"
+	RoleManager = "manager"
+	RoleOwner   = "owner"
+)
+
+//roleLevels : rank of the roles, a role allows everything the lower ones do
+var roleLevels = map[string]int{
+	RoleViewer:  1,
+	RoleEditor:  2,
+	RoleManager: 3,
+	RoleOwner:   4,
+}
+
+//ValidTeamRole : check if the role can be given to a team member
+func ValidTeamRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleOwner
+}
+
+//ValidDomainRole : check if the role can be given to a domain member
+func ValidDomainRole(role string) bool {
+	return role == RoleViewer || role == RoleEditor || role == RoleManager
+}
+
+//RoleAllows : check if the role is at least the required one
+func RoleAllows(role string, required string) bool {
+	return roleLevels[role] > 0 && roleLevels[role] >= roleLevels[required]
+}
+
+//bestRole : highest of the two roles
+func bestRole(a string, b string) string {
+	if roleLevels[b] > roleLevels[a] {
+		return b
+	}
+	return a
+}
+
+//ErrLastTeamOwner : a team must keep at least one owner
+var ErrLastTeamOwner = errors.New("the team must keep at least one owner")
+
+//Team : Struct for a team (group of users owning domains together)
+type Team struct {
+	ID          int          `gorm:"primaryKey" example:"1"`
+	Name        string       `example:"Infrastructure" gorm:"not null;uniqueIndex;size:255"`
+	Description string       `example:"Production zones" gorm:"not null;"`
+	Members     []TeamMember `gorm:"-"` //Dont save this in the DB.
+}
+
+//TeamMember : Membership of a user in a team, with its role
+type TeamMember struct {
+	ID     int    `gorm:"primaryKey" example:"1"`
+	TeamID int    `example:"1" gorm:"not null;uniqueIndex:idx_team_member"`
+	UserID int    `example:"2" gorm:"not null;uniqueIndex:idx_team_member;index"`
+	Role   string `example:"editor" gorm:"not null;size:16"`
+}
+
+//GetTeam : get team from gorm database (by id)
+func (t *Team) GetTeam(db *gorm.DB) error {
+	result := db.First(&t, t.ID)
+	return result.Error
+}
+
+//GetTeams : get the teams of the user from gorm database (all the teams for the admins)
+func GetTeams(db *gorm.DB, user User, count int, start int) ([]Team, error) {
+	teams := []Team{}
+
+	query := db.Limit(count).Offset(start).Order("name")
+	if !user.IsAdmin {
+		query = query.Where("id IN (?)", db.Model(&TeamMember{}).Select("team_id").Where("user_id = ?", user.ID))
+	}
+
+	result := query.Find(&teams)
+	return teams, result.Error
+}
+
+//CreateTeam : create team in gorm database, with its owner
+func (t *Team) CreateTeam(db *gorm.DB, owner User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&t).Error; err != nil {
+			return err
+		}
+		member := TeamMember{TeamID: t.ID, UserID: owner.ID, Role: RoleOwner}
+		return tx.Create(&member).Error
+	})
+}
+
+//UpdateTeam : update team in gorm database (by id)
+func (t *Team) UpdateTeam(db *gorm.DB) error {
+	result := db.Save(&t)
+	return result.Error
+}
+
+//DeleteTeam : delete team and its members from gorm database (by id)
+func (t *Team) DeleteTeam(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", t.ID).Delete(&TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&t).Error
+	})
+}
+
+//NameExists : check if a team with the same name already exists
+func (t *Team) NameExists(db *gorm.DB) bool {
+	var existing Team
+	result := db.Where("name = ? AND id <> ?", t.Name, t.ID).First(&existing)
+	return !errors.Is(result.Error, gorm.ErrRecordNotFound)
+}
+
+//CountDomains : number of domains owned by the team
+func (t *Team) CountDomains(db *gorm.DB) (int64, error) {
+	var count int64
+	result := db.Model(&Domain{}).Where("team_id = ?", t.ID).Count(&count)
+	return count, result.Error
+}
+
+//GetMembers : get the members of the team from gorm database
+func (t *Team) GetMembers(db *gorm.DB) ([]TeamMember, error) {
+	members := []TeamMember{}
+	result := db.Where("team_id = ?", t.ID).Order("id").Find(&members)
+	return members, result.Error
+}
+
+//GetRole : get the role of the user in the team (empty if not a member)
+func (t *Team) GetRole(db *gorm.DB, userID int) (string, error) {
+	var member TeamMember
+	result := db.Where("team_id = ? AND user_id = ?", t.ID, userID).First(&member)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return member.Role, result.Error
+}
+
+//SetMember : add the user to the team or change its role
+func (t *Team) SetMember(db *gorm.DB, userID int, role string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var member TeamMember
+		result := tx.Where("team_id = ? AND user_id = ?", t.ID, userID).First(&member)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			member = TeamMember{TeamID: t.ID, UserID: userID, Role: role}
+			return tx.Create(&member).Error
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if member.Role == RoleOwner && role != RoleOwner {
+			if err := t.keepOwner(tx, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&member).Update("role", role).Error
+	})
+}
+
+//RemoveMember : remove the user from the team
+func (t *Team) RemoveMember(db *gorm.DB, userID int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := t.keepOwner(tx, userID); err != nil {
+			return err
+		}
+		result := tx.Where("team_id = ? AND user_id = ?", t.ID, userID).Delete(&TeamMember{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+}
+
+//keepOwner : check that the team has another owner than the user
+func (t *Team) keepOwner(db *gorm.DB, userID int) error {
+	var owners int64
+	result := db.Model(&TeamMember{}).Where("team_id = ? AND role = ? AND user_id <> ?", t.ID, RoleOwner, userID).Count(&owners)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var role string
+	var member TeamMember
+	if err := db.Where("team_id = ? AND user_id = ?", t.ID, userID).First(&member).Error; err == nil {
+		role = member.Role
+	}
+	if role == RoleOwner && owners == 0 {
+		return ErrLastTeamOwner
+	}
+	return nil
+}
+
+//DeleteUserMemberships : remove the user from all its teams and shared domains (when the user is deleted)
+//ErrLastTeamOwner is returned if the user is the last owner of a team
+func DeleteUserMemberships(db *gorm.DB, userID int) error {
+	var owned []TeamMember
+	if err := db.Where("user_id = ? AND role = ?", userID, RoleOwner).Find(&owned).Error; err != nil {
+		return err
+	}
+	for _, member := range owned {
+		t := Team{ID: member.TeamID}
+		if err := t.keepOwner(db, userID); err != nil {
+			return err
+		}
+	}
+
+	if err := db.Where("user_id = ?", userID).Delete(&TeamMember{}).Error; err != nil {
+		return err
+	}
+	result := db.Where("user_id = ?", userID).Delete(&DomainMember{})
+	return result.Error
+}
+
+//DomainRole : role of the user on the domain (owner for the admins and the domain owner, best of the team and domain member roles otherwise)
+func (u User) DomainRole(db *gorm.DB, d Domain) (string, error) {
+	if u.IsAdmin || d.OwnerID == u.ID {
+		return RoleOwner, nil
+	}
+
+	role, err := d.GetMemberRole(db, u.ID)
+	if err != nil || d.TeamID == 0 {
+		return role, err
+	}
+
+	team := Team{ID: d.TeamID}
+	teamRole, err := team.GetRole(db, u.ID)
+	return bestRole(role, teamRole), err
+}
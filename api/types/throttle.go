@@ -0,0 +1,62 @@
+package types
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//LoginFailure : Failed login of an account or an IP (target), used by the SQL throttle store
+type LoginFailure struct {
+	ID     int       `gorm:"primaryKey"`
+	Target string    `gorm:"not null;size:255;index"`
+	Date   time.Time `gorm:"not null;index"`
+}
+
+//LoginLock : Lockout of an account or an IP (target), used by the SQL throttle store
+type LoginLock struct {
+	Target string    `gorm:"primaryKey;size:255"`
+	Until  time.Time `gorm:"not null;"`
+}
+
+//AddLoginFailure : save a failed login of the target in gorm database
+func AddLoginFailure(db *gorm.DB, target string, date time.Time) error {
+	result := db.Create(&LoginFailure{Target: target, Date: date})
+	return result.Error
+}
+
+//CountLoginFailures : count the failed logins of the target since the date (and delete the older ones)
+func CountLoginFailures(db *gorm.DB, target string, since time.Time) (int, error) {
+	if err := db.Where("target = ? AND date < ?", target, since).Delete(&LoginFailure{}).Error; err != nil {
+		return 0, err
+	}
+	var count int64
+	result := db.Model(&LoginFailure{}).Where("target = ? AND date >= ?", target, since).Count(&count)
+	return int(count), result.Error
+}
+
+//SetLoginLock : lock the target until the date
+func SetLoginLock(db *gorm.DB, target string, until time.Time) error {
+	result := db.Save(&LoginLock{Target: target, Until: until})
+	return result.Error
+}
+
+//GetLoginLock : get the end of the lockout of the target (zero if not locked)
+func GetLoginLock(db *gorm.DB, target string) (time.Time, error) {
+	var locks []LoginLock
+	result := db.Where("target = ? AND until > ?", target, time.Now()).Find(&locks)
+	if result.Error != nil || len(locks) == 0 {
+		return time.Time{}, result.Error
+	}
+	return locks[0].Until, nil
+}
+
+//ResetLogin : delete the failed logins and the lockout of the target
+func ResetLogin(db *gorm.DB, target string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("target = ?", target).Delete(&LoginFailure{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("target = ?", target).Delete(&LoginLock{}).Error
+	})
+}
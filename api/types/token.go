@@ -0,0 +1,228 @@
+package types
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//Token scopes :
+// read : read everything the user can read
+// write : read and write everything the user can (except administration)
+// records:<domain_id> : read the domain and write its records
+// admin : administration (only for admin users)
+const (
+	ScopeRead    = "read"
+	ScopeWrite   = "write"
+	ScopeAdmin   = "admin"
+	ScopeRecords = "records:"
+)
+
+//TokenPrefixLength : length of the secret start stored in clear to find the token
+const TokenPrefixLength = 8
+
+//tokenTouchInterval : the last usage date is saved at most once by interval (not at every request)
+const tokenTouchInterval = time.Minute
+
+//Scopes : List of token scopes, stored space separated in the database
+type Scopes []string
+
+//Value : write the scopes in the database
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+//Scan : read the scopes from the database
+func (s *Scopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*s = strings.Fields(v)
+	case []byte:
+		*s = strings.Fields(string(v))
+	case nil:
+		*s = Scopes{}
+	default:
+		return fmt.Errorf("can't scan scopes from %T", value)
+	}
+	return nil
+}
+
+//GormDataType : scopes are stored as a string
+func (Scopes) GormDataType() string {
+	return "string"
+}
+
+//Validate : check that every scope is known
+func (s Scopes) Validate() error {
+	for _, scope := range s {
+		if scope == ScopeRead || scope == ScopeWrite || scope == ScopeAdmin {
+			continue
+		}
+		if id, err := strconv.Atoi(strings.TrimPrefix(scope, ScopeRecords)); strings.HasPrefix(scope, ScopeRecords) && err == nil && id > 0 {
+			continue
+		}
+		return fmt.Errorf("unknown scope %q (read, write, admin or records:<domain_id>)", scope)
+	}
+	return nil
+}
+
+//Token : Named API token of a user, with its scopes and an optional expiration date
+type Token struct {
+	ID         int        `gorm:"primaryKey" example:"1"`
+	UserID     int        `example:"2" gorm:"not null;index"`
+	Name       string     `example:"CI deploy" gorm:"not null;"`
+	Prefix     string     `example:"Xk3v9QaL" gorm:"not null;size:16;index"`
+	Hash       string     `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	Secret     string     `json:"-" gorm:"-"` //Only known at creation, never saved
+	Scopes     Scopes     `example:"read,records:1" swaggertype:"array,string" gorm:"not null;"`
+	ExpiresAt  *time.Time `gorm:"index"`
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+//HasScope : check if the token has the scope
+func (t Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//Expired : check if the token expiration date is passed
+func (t Token) Expired() bool {
+	return t.ExpiresAt != nil && t.ExpiresAt.Before(time.Now())
+}
+
+//RecordsDomains : IDs of the domains the token can write records in (records:<domain_id> scopes)
+func (t Token) RecordsDomains() []int {
+	ids := []int{}
+	for _, s := range t.Scopes {
+		if strings.HasPrefix(s, ScopeRecords) {
+			if id, err := strconv.Atoi(strings.TrimPrefix(s, ScopeRecords)); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+//Restricted : check if the token only gives access to some domains (records:<domain_id> scopes only)
+func (t Token) Restricted() bool {
+	return !t.HasScope(ScopeRead) && !t.HasScope(ScopeWrite) && !t.HasScope(ScopeAdmin)
+}
+
+//AllowsDomain : check if the token gives read (write = false) or write access to the domain
+func (t Token) AllowsDomain(domainID int, write bool) bool {
+	if t.HasScope(ScopeWrite) || t.HasScope(ScopeAdmin) {
+		return true
+	}
+	if !write && t.HasScope(ScopeRead) {
+		return true
+	}
+	for _, id := range t.RecordsDomains() {
+		if id == domainID {
+			return true
+		}
+	}
+	return false
+}
+
+//GetToken : get token from gorm database (by id)
+func (t *Token) GetToken(db *gorm.DB) error {
+	result := db.First(&t, t.ID)
+	return result.Error
+}
+
+//hashSecret : hash of a token secret, as stored in the database
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//GetTokenBySecret : get token from gorm database (by secret, using its prefix and hash)
+func (t *Token) GetTokenBySecret(db *gorm.DB) error {
+	if len(t.Secret) <= TokenPrefixLength {
+		return gorm.ErrRecordNotFound
+	}
+
+	var tokens []Token
+	result := db.Where("prefix = ?", t.Secret[:TokenPrefixLength]).Find(&tokens)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	hash := hashSecret(t.Secret)
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			token.Secret = t.Secret
+			*t = token
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+//GetUserTokens : get all the tokens of a user from gorm database
+func (u *User) GetUserTokens(db *gorm.DB) ([]Token, error) {
+	tokens := []Token{}
+	result := db.Where("user_id = ?", u.ID).Order("id").Find(&tokens)
+	return tokens, result.Error
+}
+
+//CreateToken : create token in gorm database
+func (t *Token) CreateToken(db *gorm.DB) error {
+	if len(t.Secret) <= TokenPrefixLength {
+		return errors.New("token secret too short")
+	}
+	t.Prefix = t.Secret[:TokenPrefixLength]
+	t.Hash = hashSecret(t.Secret)
+	result := db.Create(&t)
+	return result.Error
+}
+
+//Touch : save the last usage date of the token (if the saved one is older than tokenTouchInterval)
+func (t *Token) Touch(db *gorm.DB) error {
+	now := time.Now()
+	if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) < tokenTouchInterval {
+		return nil
+	}
+	t.LastUsedAt = &now
+	result := db.Model(&Token{}).Where("id = ?", t.ID).Update("last_used_at", now)
+	return result.Error
+}
+
+//DeleteToken : delete (revoke) token from gorm database (by id)
+func (t *Token) DeleteToken(db *gorm.DB) error {
+	result := db.Delete(&t)
+	return result.Error
+}
+
+//DeleteTokens : delete (revoke) all the tokens of a user from gorm database
+func (u *User) DeleteTokens(db *gorm.DB) error {
+	result := db.Where("user_id = ?", u.ID).Delete(&Token{})
+	return result.Error
+}
+
+//DeleteExpiredTokens : delete the expired tokens of a user from gorm database
+func (u *User) DeleteExpiredTokens(db *gorm.DB) error {
+	result := db.Where("user_id = ? AND expires_at < ?", u.ID, time.Now()).Delete(&Token{})
+	return result.Error
+}
+
+//DefaultScopes : scopes of the tokens created at login or with the user
+func (u User) DefaultScopes() Scopes {
+	if u.IsAdmin {
+		return Scopes{ScopeAdmin}
+	}
+	return Scopes{ScopeWrite}
+}
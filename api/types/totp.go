@@ -0,0 +1,160 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//TOTP parameters (RFC 6238 defaults, supported by all the authenticator apps)
+const (
+	TOTPIssuer         = "Sacrebleu"
+	totpDigits         = 6
+	totpPeriod         = 30
+	totpSkew           = 1 //Accepted steps before and after the current one
+	recoveryCodesCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//RecoveryCode : One-time code to login without the TOTP device (only its hash is saved)
+type RecoveryCode struct {
+	ID     int    `gorm:"primaryKey"`
+	UserID int    `gorm:"not null;index"`
+	Hash   string `gorm:"not null;size:64;uniqueIndex"`
+}
+
+//totpCode : TOTP code of the secret for the time step (RFC 4226 HOTP)
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+//GenerateTOTPSecret : set a new random TOTP secret to the user (not enabled until confirmed)
+func (u *User) GenerateTOTPSecret() error {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	u.TOTPSecret = totpEncoding.EncodeToString(b)
+	u.TOTPEnabled = false
+	u.TOTPLastStep = 0
+	return nil
+}
+
+//TOTPURI : otpauth URI of the user TOTP secret (to show as a QR code)
+func (u User) TOTPURI() string {
+	label := url.PathEscape(fmt.Sprintf("%s:%s", TOTPIssuer, u.Username))
+	v := url.Values{}
+	v.Set("secret", u.TOTPSecret)
+	v.Set("issuer", TOTPIssuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+//CheckTOTP : check the TOTP code of the user and save its time step (a code can only be used once)
+func (u *User) CheckTOTP(db *gorm.DB, code string) bool {
+	secret, err := totpEncoding.DecodeString(u.TOTPSecret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		if step <= u.TOTPLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			u.TOTPLastStep = step
+			db.Model(&User{}).Where("id = ?", u.ID).Update("totp_last_step", step)
+			return true
+		}
+	}
+	return false
+}
+
+//EnableTOTP : require the TOTP code at login
+func (u *User) EnableTOTP(db *gorm.DB) error {
+	u.TOTPEnabled = true
+	result := db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{"totp_secret": u.TOTPSecret, "totp_enabled": true, "totp_last_step": u.TOTPLastStep})
+	return result.Error
+}
+
+//SaveTOTPSecret : save the TOTP secret of an enrolment (not confirmed yet)
+func (u *User) SaveTOTPSecret(db *gorm.DB) error {
+	result := db.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{"totp_secret": u.TOTPSecret, "totp_enabled": false, "totp_last_step": 0})
+	return result.Error
+}
+
+//DisableTOTP : remove the TOTP secret and the recovery codes of the user
+func (u *User) DisableTOTP(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep = "", false, 0
+		if err := tx.Model(&User{}).Where("id = ?", u.ID).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+//normalizeRecoveryCode : recovery codes are case and dash insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+//GenerateRecoveryCodes : replace the recovery codes of the user by new ones (returned in clear only once)
+func (u *User) GenerateRecoveryCodes(db *gorm.DB) ([]string, error) {
+	codes := []string{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", u.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := 0; i < recoveryCodesCount; i++ {
+			b := make([]byte, 5)
+			if _, err := rand.Read(b); err != nil {
+				return err
+			}
+			code := strings.ToLower(totpEncoding.EncodeToString(b)) //8 characters
+			if err := tx.Create(&RecoveryCode{UserID: u.ID, Hash: hashSecret(code)}).Error; err != nil {
+				return err
+			}
+			codes = append(codes, code[:4]+"-"+code[4:])
+		}
+		return nil
+	})
+	return codes, err
+}
+
+//UseRecoveryCode : check the recovery code of the user and delete it
+func (u *User) UseRecoveryCode(db *gorm.DB, code string) bool {
+	result := db.Where("user_id = ? AND hash = ?", u.ID, hashSecret(normalizeRecoveryCode(code))).Delete(&RecoveryCode{})
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+//CheckSecondFactor : check a TOTP code or a recovery code of the user
+func (u *User) CheckSecondFactor(db *gorm.DB, code string) bool {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false
+	}
+	return u.CheckTOTP(db, code) || u.UseRecoveryCode(db, code)
+}
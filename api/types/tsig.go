@@ -0,0 +1,179 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gorm.io/gorm"
+)
+
+//TSIG key permissions on a domain
+const (
+	TSIGTransfer = "transfer" //Zone transfers (AXFR / IXFR)
+	TSIGUpdate   = "update"   //RFC 2136 dynamic updates (the records but NS and SOA)
+)
+
+//TSIGAlgorithms : algorithms of the TSIG keys managed by the API (by their short name)
+var TSIGAlgorithms = map[string]string{
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+//tsigKeyName : key names are hostnames (the nameservers configurations need them unquoted)
+var tsigKeyName = regexp.MustCompile(`^([a-z0-9_-]+\.)+$`)
+
+//TSIGKey : Key signing the zone transfers and the dynamic updates (its secret is generated by the API)
+type TSIGKey struct {
+	ID        int    `gorm:"primaryKey" example:"1"`
+	Name      string `example:"dhcp.example.org." gorm:"not null;size:255;uniqueIndex"`
+	Algorithm string `example:"hmac-sha256" gorm:"not null;size:16"`
+	Secret    string `json:"-" gorm:"not null;size:128"`           //Base64, needed in clear to sign the messages
+	UserID    int    `example:"1" gorm:"not null;default:0;index"` //Creator of the key, the dynamic updates are logged with its name
+	CreatedAt time.Time
+}
+
+//TSIGKeyDomain : Domain a TSIG key is attached to, and what the key can do on it
+type TSIGKeyDomain struct {
+	ID          int    `gorm:"primaryKey" example:"1"`
+	KeyID       int    `example:"1" gorm:"not null;uniqueIndex:idx_tsig_key_domain"`
+	DomainID    int    `example:"1" gorm:"not null;uniqueIndex:idx_tsig_key_domain;index"`
+	Permissions Scopes `example:"transfer,update" swaggertype:"array,string" gorm:"not null;"`
+}
+
+//Validate : check the name and the algorithm of the key (the name is made fully qualified)
+func (k *TSIGKey) Validate() error {
+	k.Name = dns.Fqdn(strings.ToLower(strings.TrimSpace(k.Name)))
+	if _, ok := dns.IsDomainName(k.Name); !ok || !tsigKeyName.MatchString(k.Name) {
+		return fmt.Errorf("invalid key name %q", k.Name)
+	}
+	if _, ok := TSIGAlgorithms[k.Algorithm]; !ok {
+		return fmt.Errorf("unsupported algorithm %q (hmac-sha256 or hmac-sha512)", k.Algorithm)
+	}
+	return nil
+}
+
+//ValidTSIGPermissions : check that the permissions are known and not empty
+func ValidTSIGPermissions(permissions Scopes) error {
+	if len(permissions) == 0 {
+		return errors.New("no permission (transfer, update)")
+	}
+	for _, p := range permissions {
+		if p != TSIGTransfer && p != TSIGUpdate {
+			return fmt.Errorf("unknown permission %q (transfer, update)", p)
+		}
+	}
+	return nil
+}
+
+//CreateTSIGKey : create key in gorm database
+func (k *TSIGKey) CreateTSIGKey(db *gorm.DB) error {
+	result := db.Create(&k)
+	return result.Error
+}
+
+//GetTSIGKey : get key from gorm database (by id)
+func (k *TSIGKey) GetTSIGKey(db *gorm.DB) error {
+	result := db.First(&k, k.ID)
+	return result.Error
+}
+
+//GetTSIGKeyByName : get key from gorm database (by name)
+func (k *TSIGKey) GetTSIGKeyByName(db *gorm.DB) error {
+	result := db.Where("name = ?", dns.Fqdn(strings.ToLower(k.Name))).First(&k)
+	return result.Error
+}
+
+//GetTSIGKeys : get the keys from gorm database
+func GetTSIGKeys(db *gorm.DB, count int, start int) ([]TSIGKey, error) {
+	keys := []TSIGKey{}
+	result := db.Order("id").Limit(count).Offset(start).Find(&keys)
+	return keys, result.Error
+}
+
+//NameExists : check if another key has the name
+func (k *TSIGKey) NameExists(db *gorm.DB) (bool, error) {
+	var count int64
+	result := db.Model(&TSIGKey{}).Where("name = ? AND id <> ?", k.Name, k.ID).Count(&count)
+	return count > 0, result.Error
+}
+
+//UpdateTSIGKey : update key in gorm database (by id)
+func (k *TSIGKey) UpdateTSIGKey(db *gorm.DB) error {
+	result := db.Model(&k).Select("name", "algorithm", "secret").Updates(k)
+	return result.Error
+}
+
+//DeleteTSIGKey : delete key and its domains from gorm database (by id)
+func (k *TSIGKey) DeleteTSIGKey(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("key_id = ?", k.ID).Delete(&TSIGKeyDomain{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&k).Error
+	})
+}
+
+//DeleteUserTSIGKeys : delete the keys created by the user and their domains (when the user is deleted)
+func DeleteUserTSIGKeys(db *gorm.DB, userID int) error {
+	var ids []int
+	if err := db.Model(&TSIGKey{}).Where("user_id = ?", userID).Pluck("id", &ids).Error; err != nil || len(ids) == 0 {
+		return err
+	}
+	if err := db.Where("key_id IN ?", ids).Delete(&TSIGKeyDomain{}).Error; err != nil {
+		return err
+	}
+	result := db.Where("id IN ?", ids).Delete(&TSIGKey{})
+	return result.Error
+}
+
+//GetDomains : get the domains the key is attached to from gorm database
+func (k *TSIGKey) GetDomains(db *gorm.DB) ([]TSIGKeyDomain, error) {
+	domains := []TSIGKeyDomain{}
+	result := db.Where("key_id = ?", k.ID).Order("id").Find(&domains)
+	return domains, result.Error
+}
+
+//SetDomain : attach the key to the domain or change its permissions on it
+func (k *TSIGKey) SetDomain(db *gorm.DB, domainID int, permissions Scopes) error {
+	attached := TSIGKeyDomain{KeyID: k.ID, DomainID: domainID}
+	result := db.Where("key_id = ? AND domain_id = ?", k.ID, domainID).Assign(TSIGKeyDomain{Permissions: permissions}).FirstOrCreate(&attached)
+	return result.Error
+}
+
+//RemoveDomain : detach the key from the domain
+func (k *TSIGKey) RemoveDomain(db *gorm.DB, domainID int) error {
+	result := db.Where("key_id = ? AND domain_id = ?", k.ID, domainID).Delete(&TSIGKeyDomain{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+//Allows : check if the key has the permission on the domain
+func (k *TSIGKey) Allows(db *gorm.DB, domainID int, permission string) (bool, error) {
+	var attached TSIGKeyDomain
+	result := db.Where("key_id = ? AND domain_id = ?", k.ID, domainID).First(&attached)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	for _, p := range attached.Permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//DeleteTSIGKeyDomains : detach every key from the domain (when the domain is deleted)
+func (d *Domain) DeleteTSIGKeyDomains(db *gorm.DB) error {
+	result := db.Where("domain_id = ?", d.ID).Delete(&TSIGKeyDomain{})
+	return result.Error
+}
@@ -6,15 +6,29 @@ import (
 	"gorm.io/gorm"
 )
 
+//Authentication providers of the users
+const (
+	ProviderLocal = "local"
+	ProviderLDAP  = "ldap"
+	ProviderOIDC  = "oidc"
+)
+
 //User : Struct for a user (to access to the API)
 type User struct {
 	ID       int      `gorm:"primaryKey"`
 	Email    string   `gorm:"not null;"`
 	Username string   `gorm:"not null;"`
 	Password string   `gorm:"not null;"`
-	Token    string   `gorm:"not null;"`
 	IsAdmin  bool     `gorm:"not null;"`
 	Domains  []Domain `gorm:"-"` //Dont save this in the DB.
+
+	EmailVerified bool   `json:"-" gorm:"not null;default:false"`         //Email confirmed with a link sent to it
+	Provider      string `json:"-" gorm:"not null;size:32;default:local"` //Authentication provider (local, ldap, oidc)
+	ExternalID    string `json:"-" gorm:"size:255;index"`                 //ID of the user at the provider (eg : OIDC issuer and subject)
+
+	TOTPSecret   string `json:"-" gorm:"size:64"`                //Base32 TOTP secret (set by the enrolment)
+	TOTPEnabled  bool   `json:"-" gorm:"not null;default:false"` //Login requires a TOTP or recovery code
+	TOTPLastStep int64  `json:"-"`                               //Last used TOTP time step (no code replay)
 }
 
 //IsOwner : Check if user owns domain
@@ -31,6 +45,12 @@ func (u *User) GetUser(db *gorm.DB) error {
 	return result.Error
 }
 
+//GetUserByExternalID : get user from gorm database (by provider and external ID)
+func (u *User) GetUserByExternalID(db *gorm.DB) error {
+	result := db.Where("provider = ? AND external_id = ?", u.Provider, u.ExternalID).First(&u)
+	return result.Error
+}
+
 //GetUserByUsername : get user from gorm database (by username)
 func (u *User) GetUserByUsername(db *gorm.DB) error {
 	result := db.Where("username = ?", u.Username).First(&u)
@@ -42,6 +62,9 @@ func (u *User) CreateUser(db *gorm.DB) error {
 	if u.EmailExists(db) || u.UsernameExists(db) {
 		return gorm.ErrRegistered
 	}
+	if u.Provider == "" {
+		u.Provider = ProviderLocal
+	}
 	result := db.Create(&u)
 	return result.Error
 }
@@ -0,0 +1,290 @@
+package types
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+//ValidationError : Field-level explanation of why a submitted object is refused
+type ValidationError struct {
+	Field   string `example:"Content"`
+	Message string `example:"invalid IPv4 address"`
+}
+
+//contentValidator : check the fields of a record content for one type
+type contentValidator func(fields []string) error
+
+//contentValidators : validators by record type (Qtype)
+//Types without validator are only checked by the miekg/dns parser
+var contentValidators = map[RRType]contentValidator{
+	1:   validateA,     //A
+	2:   validateName,  //NS
+	5:   validateName,  //CNAME
+	6:   validateSOA,   //SOA
+	12:  validateName,  //PTR
+	15:  validateMX,    //MX
+	16:  validateTXT,   //TXT
+	28:  validateAAAA,  //AAAA
+	33:  validateSRV,   //SRV
+	43:  validateDS,    //DS
+	44:  validateSSHFP, //SSHFP
+	52:  validateTLSA,  //TLSA
+	257: validateCAA,   //CAA
+}
+
+//Validate : check the record name, TTL, type and content (according to the type)
+//Return nil if the record is valid
+func (r *Record) Validate() []ValidationError {
+	var errs []ValidationError
+
+	if _, ok := dns.IsDomainName(r.Fqdn); !ok || !dns.IsFqdn(r.Fqdn) {
+		errs = append(errs, ValidationError{Field: "Fqdn", Message: "must be a fully qualified domain name (ending with a dot)"})
+	}
+	if r.TTL < 0 || r.TTL > 2147483647 { //RFC 2181 8.
+		errs = append(errs, ValidationError{Field: "TTL", Message: "must be between 0 and 2147483647"})
+	}
+	if r.Type < 1 || r.Type > 65535 {
+		errs = append(errs, ValidationError{Field: "Type", Message: "unknown record type"})
+		return errs
+	}
+	if r.Type.Meta() {
+		errs = append(errs, ValidationError{Field: "Type", Message: fmt.Sprintf("%s is a meta type and can't be stored", r.Type)})
+		return errs
+	}
+
+	if err := validateContent(r.Type, r.Content); err != nil {
+		errs = append(errs, ValidationError{Field: "Content", Message: fmt.Sprintf("invalid %s content : %s", r.Type, err)})
+	}
+
+	return errs
+}
+
+//validateContent : run the type validator then check that sacrebleu-dns will be able to serve the record
+func validateContent(qtype RRType, content string) error {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return errors.New("empty content")
+	}
+
+	if validator, ok := contentValidators[qtype]; ok {
+		if err := validator(fields); err != nil {
+			return err
+		}
+	}
+
+	typeName, ok := dns.TypeToString[uint16(qtype)]
+	if !ok {
+		return nil //Unknown to miekg/dns, can't check more
+	}
+	rr, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %s", typeName, content))
+	if err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "dns: "))
+	}
+	if rr == nil {
+		return errors.New("empty content")
+	}
+	return nil
+}
+
+//expectFields : check the number of fields of the content
+func expectFields(fields []string, n int, format string) error {
+	if len(fields) != n {
+		return fmt.Errorf("expected \"%s\"", format)
+	}
+	return nil
+}
+
+//checkName : the name must be a fully qualified domain name
+func checkName(name string) error {
+	if _, ok := dns.IsDomainName(name); !ok || !dns.IsFqdn(name) {
+		return fmt.Errorf("%q is not a fully qualified domain name (ending with a dot)", name)
+	}
+	return nil
+}
+
+//checkUint : the value must be an unsigned integer between min and max
+func checkUint(name string, value string, min uint64, max uint64) error {
+	v, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || v < min || v > max {
+		return fmt.Errorf("%s must be an integer between %v and %v", name, min, max)
+	}
+	return nil
+}
+
+//checkHex : the value must be an hexadecimal string, of the given length if length > 0
+func checkHex(name string, value string, length int) error {
+	if _, err := hex.DecodeString(value); err != nil {
+		return fmt.Errorf("%s must be an hexadecimal string", name)
+	}
+	if length > 0 && len(value) != length {
+		return fmt.Errorf("%s must be %v hexadecimal characters long", name, length)
+	}
+	return nil
+}
+
+func validateA(fields []string) error {
+	if err := expectFields(fields, 1, "<IPv4 address>"); err != nil {
+		return err
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil || ip.To4() == nil || strings.Contains(fields[0], ":") {
+		return fmt.Errorf("%q is not an IPv4 address", fields[0])
+	}
+	return nil
+}
+
+func validateAAAA(fields []string) error {
+	if err := expectFields(fields, 1, "<IPv6 address>"); err != nil {
+		return err
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil || !strings.Contains(fields[0], ":") {
+		return fmt.Errorf("%q is not an IPv6 address", fields[0])
+	}
+	return nil
+}
+
+//validateName : NS, CNAME and PTR
+func validateName(fields []string) error {
+	if err := expectFields(fields, 1, "<target fqdn>"); err != nil {
+		return err
+	}
+	return checkName(fields[0])
+}
+
+func validateMX(fields []string) error {
+	if err := expectFields(fields, 2, "<preference> <exchange fqdn>"); err != nil {
+		return err
+	}
+	if err := checkUint("preference", fields[0], 0, 65535); err != nil {
+		return err
+	}
+	return checkName(fields[1])
+}
+
+func validateTXT(fields []string) error {
+	rr, err := dns.NewRR(". 0 IN TXT " + strings.Join(fields, " "))
+	if err != nil || rr == nil {
+		return errors.New("expected one or more character strings")
+	}
+	for _, txt := range rr.(*dns.TXT).Txt {
+		if len(txt) > 255 { //RFC 1035 3.3.
+			return errors.New("a character string can't be longer than 255 characters, split it in multiple quoted strings")
+		}
+	}
+	return nil
+}
+
+func validateSRV(fields []string) error {
+	if err := expectFields(fields, 4, "<priority> <weight> <port> <target fqdn>"); err != nil {
+		return err
+	}
+	for i, name := range []string{"priority", "weight", "port"} {
+		if err := checkUint(name, fields[i], 0, 65535); err != nil {
+			return err
+		}
+	}
+	if fields[3] == "." { //RFC 2782 : service not available
+		return nil
+	}
+	return checkName(fields[3])
+}
+
+//caaTag : RFC 8659 4.1.
+var caaTag = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+func validateCAA(fields []string) error {
+	if len(fields) < 3 {
+		return errors.New("expected \"<flags> <tag> <value>\"")
+	}
+	if err := checkUint("flags", fields[0], 0, 255); err != nil {
+		return err
+	}
+	if !caaTag.MatchString(fields[1]) {
+		return fmt.Errorf("tag %q must be alphanumeric", fields[1])
+	}
+	value := strings.Join(fields[2:], " ")
+	if len(fields) > 3 && (!strings.HasPrefix(value, "\"") || !strings.HasSuffix(value, "\"")) {
+		return errors.New("value containing spaces must be a quoted string")
+	}
+	return nil
+}
+
+func validateSOA(fields []string) error {
+	if err := expectFields(fields, 7, "<mname> <rname> <serial> <refresh> <retry> <expire> <minimum>"); err != nil {
+		return err
+	}
+	if err := checkName(fields[0]); err != nil {
+		return err
+	}
+	if err := checkName(fields[1]); err != nil {
+		return err
+	}
+	for i, name := range []string{"serial", "refresh", "retry", "expire", "minimum"} {
+		if err := checkUint(name, fields[i+2], 0, 4294967295); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//dsDigestLengths : digest length (in hex characters) by DS digest type
+var dsDigestLengths = map[string]int{"1": 40, "2": 64, "3": 64, "4": 96}
+
+func validateDS(fields []string) error {
+	if len(fields) < 4 {
+		return errors.New("expected \"<key tag> <algorithm> <digest type> <digest>\"")
+	}
+	if err := checkUint("key tag", fields[0], 0, 65535); err != nil {
+		return err
+	}
+	if err := checkUint("algorithm", fields[1], 0, 255); err != nil {
+		return err
+	}
+	if err := checkUint("digest type", fields[2], 0, 255); err != nil {
+		return err
+	}
+	return checkHex("digest", strings.Join(fields[3:], ""), dsDigestLengths[fields[2]])
+}
+
+//tlsaDigestLengths : data length (in hex characters) by TLSA matching type
+var tlsaDigestLengths = map[string]int{"1": 64, "2": 128}
+
+func validateTLSA(fields []string) error {
+	if len(fields) < 4 {
+		return errors.New("expected \"<usage> <selector> <matching type> <certificate data>\"")
+	}
+	if err := checkUint("usage", fields[0], 0, 3); err != nil {
+		return err
+	}
+	if err := checkUint("selector", fields[1], 0, 1); err != nil {
+		return err
+	}
+	if err := checkUint("matching type", fields[2], 0, 2); err != nil {
+		return err
+	}
+	return checkHex("certificate data", strings.Join(fields[3:], ""), tlsaDigestLengths[fields[2]])
+}
+
+//sshfpDigestLengths : fingerprint length (in hex characters) by SSHFP fingerprint type
+var sshfpDigestLengths = map[string]int{"1": 40, "2": 64}
+
+func validateSSHFP(fields []string) error {
+	if len(fields) < 3 {
+		return errors.New("expected \"<algorithm> <fingerprint type> <fingerprint>\"")
+	}
+	if err := checkUint("algorithm", fields[0], 1, 6); err != nil {
+		return err
+	}
+	if err := checkUint("fingerprint type", fields[1], 1, 2); err != nil {
+		return err
+	}
+	return checkHex("fingerprint", strings.Join(fields[2:], ""), sshfpDigestLengths[fields[1]])
+}
@@ -0,0 +1,322 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gorm.io/gorm"
+)
+
+//defaultZoneTTL : $TTL used when the domain has no SOA yet
+const defaultZoneTTL = 3600
+
+//transferTimeout : dial, read and write timeout of the zone transfers from a primary server
+const transferTimeout = 10 * time.Second
+
+//ZoneTransfer : Result of a zone import by AXFR from a primary server
+type ZoneTransfer struct {
+	Primary string `example:"192.0.2.1:53"`
+	Serial  uint32 `example:"2021011803"` //Serial of the zone on the primary
+	Skipped int    `example:"0"`          //DNSSEC records made by the primary signer (RRSIG, NSEC, NSEC3, NSEC3PARAM), not imported
+	Changes ZoneChanges
+}
+
+//ErrImportRefused : the changes of the import were refused by its caller
+var ErrImportRefused = errors.New("import refused")
+
+//TransferError : the zone transfer from the primary server failed
+type TransferError struct {
+	Primary string
+	Err     error
+}
+
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("zone transfer from %s failed : %s", e.Primary, e.Err)
+}
+
+//RecordError : a record to import is invalid
+type RecordError struct {
+	Record Record
+	Errors []ValidationError
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("invalid record %s %v %s", e.Record.Fqdn, e.Record.TTL, e.Record.Content)
+}
+
+//ExportZone : render all the domain records as an RFC 1035 master file
+//The SOA is written first, records that can't be parsed are kept as comments
+func (d *Domain) ExportZone(db *gorm.DB) (string, error) {
+	records, err := d.GetDomainRecords(db, -1, -1)
+	if err != nil {
+		return "", err
+	}
+
+	//SOA first (RFC 1035 5.2.), then the records in database order
+	ttl := defaultZoneTTL
+	sorted := make([]Record, 0, len(records))
+	for _, r := range records {
+		if r.Type == 6 {
+			ttl = r.TTL
+			sorted = append([]Record{r}, sorted...)
+		} else {
+			sorted = append(sorted, r)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "; Zone %s exported by sacrebleu-api on %s\n", d.Fqdn, time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "$ORIGIN %s\n", d.Fqdn)
+	fmt.Fprintf(&b, "$TTL %v\n", ttl)
+
+	for _, r := range sorted {
+		rr, err := r.RR()
+		if err != nil {
+			fmt.Fprintf(&b, "; record %v skipped (%s) : %s %v %s %s\n", r.ID, err, r.Fqdn, r.TTL, r.Type, r.Content)
+			continue
+		}
+		fmt.Fprintln(&b, rr.String())
+	}
+
+	return b.String(), nil
+}
+
+//ZoneChanges : Records to create, update and delete in a domain
+type ZoneChanges struct {
+	Created []Record
+	Updated []Record
+	Deleted []Record
+}
+
+//RecordOperation : One operation of a batch of record changes
+//Action is "create", "update" (the whole record is replaced, ID required) or "delete" (only the ID is used)
+type RecordOperation struct {
+	Action string `example:"create"`
+	Record Record
+}
+
+//Empty : check if there is nothing to apply
+func (c ZoneChanges) Empty() bool {
+	return len(c.Created) == 0 && len(c.Updated) == 0 && len(c.Deleted) == 0
+}
+
+//Touched : every record changed, with the previous version of the updated ones (taken from existing)
+func (c ZoneChanges) Touched(existing []Record) []Record {
+	previous := make(map[int]Record, len(existing))
+	for _, r := range existing {
+		previous[r.ID] = r
+	}
+
+	touched := append(append(append([]Record{}, c.Created...), c.Updated...), c.Deleted...)
+	for _, r := range c.Updated {
+		if before, ok := previous[r.ID]; ok {
+			touched = append(touched, before)
+		}
+	}
+	return touched
+}
+
+//RecordFromRR : convert a miekg/dns resource record to a Record of the domain
+func RecordFromRR(rr dns.RR, domainID int) Record {
+	hdr := rr.Header()
+	return Record{
+		DomainID: domainID,
+		Fqdn:     hdr.Name,
+		Type:     RRType(hdr.Rrtype),
+		TTL:      int(hdr.Ttl),
+		Content:  strings.TrimPrefix(rr.String(), hdr.String()),
+	}
+}
+
+//ParseZone : parse an RFC 1035 master file into records of the domain
+//$INCLUDE is refused and the SOA is skipped (it is generated by UpdateSOA)
+func (d *Domain) ParseZone(zone io.Reader) ([]Record, error) {
+	records := []Record{}
+
+	zp := dns.NewZoneParser(zone, d.Fqdn, "")
+	zp.SetDefaultTTL(defaultZoneTTL)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if !dns.IsSubDomain(d.Fqdn, rr.Header().Name) {
+			return nil, fmt.Errorf("%s is out of zone %s", rr.Header().Name, d.Fqdn)
+		}
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+		records = append(records, RecordFromRR(rr, d.ID))
+	}
+
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+//TransferZone : get the records of the domain from a primary server by AXFR, signed with the key if not nil
+//The SOA is skipped (it is generated by UpdateSOA) and the DNSSEC records made by the primary are counted as skipped
+func (d *Domain) TransferZone(primary string, key *TSIGKey) ([]Record, ZoneTransfer, error) {
+	if _, _, err := net.SplitHostPort(primary); err != nil {
+		primary = net.JoinHostPort(primary, "53")
+	}
+	transfer := ZoneTransfer{Primary: primary}
+	records := []Record{}
+
+	m := new(dns.Msg)
+	m.SetAxfr(d.Fqdn)
+	t := &dns.Transfer{DialTimeout: transferTimeout, ReadTimeout: transferTimeout, WriteTimeout: transferTimeout}
+	if key != nil {
+		t.TsigSecret = map[string]string{key.Name: key.Secret}
+		m.SetTsig(key.Name, TSIGAlgorithms[key.Algorithm], 300, time.Now().Unix())
+	}
+
+	envelopes, err := t.In(m, primary)
+	if err != nil {
+		return nil, transfer, err
+	}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, transfer, envelope.Error
+		}
+		for _, rr := range envelope.RR {
+			if !dns.IsSubDomain(d.Fqdn, rr.Header().Name) {
+				return nil, transfer, fmt.Errorf("%s is out of zone %s", rr.Header().Name, d.Fqdn)
+			}
+			switch rr.Header().Rrtype {
+			case dns.TypeSOA:
+				transfer.Serial = rr.(*dns.SOA).Serial
+			case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeNSEC3PARAM:
+				transfer.Skipped++
+			default:
+				records = append(records, RecordFromRR(rr, d.ID))
+			}
+		}
+	}
+	return records, transfer, nil
+}
+
+//ImportTransfer : transfer the zone from the primary server and import its records in the domain (in the name of the user)
+//The changes are checked by refused (if not nil, ErrImportRefused if it returns true) and for conflicts, then applied unless dryRun
+func (d *Domain) ImportTransfer(db *gorm.DB, user User, primary string, key *TSIGKey, replace bool, dryRun bool, refused func(touched []Record) bool) (ZoneTransfer, error) {
+	records, transfer, err := d.TransferZone(primary, key)
+	if err != nil {
+		return transfer, &TransferError{Primary: transfer.Primary, Err: err}
+	}
+	for _, record := range records {
+		if errs := record.Validate(); errs != nil {
+			return transfer, &RecordError{Record: record, Errors: errs}
+		}
+	}
+
+	existing, err := d.GetDomainRecords(db, -1, -1)
+	if err != nil {
+		return transfer, err
+	}
+	transfer.Changes = DiffZone(existing, records, replace)
+	if refused != nil && refused(transfer.Changes.Touched(existing)) {
+		return transfer, ErrImportRefused
+	}
+	if conflict := CheckChangesConflicts(d.Fqdn, existing, transfer.Changes); conflict != nil {
+		return transfer, conflict
+	}
+	if dryRun || transfer.Changes.Empty() {
+		return transfer, nil
+	}
+	return transfer, d.ApplyChanges(db, user, "zone.import.axfr", transfer.Changes)
+}
+
+//recordKey : identify a record by its name, type and canonical content (TTL excluded)
+func recordKey(r Record) string {
+	content := r.Content
+	if rr, err := r.RR(); err == nil {
+		content = RecordFromRR(rr, r.DomainID).Content
+	}
+	return fmt.Sprintf("%s %v %s", strings.ToLower(r.Fqdn), r.Type, content)
+}
+
+//SameRecord : check if the records have the same name, type and content (TTL excluded)
+func SameRecord(a Record, b Record) bool {
+	return recordKey(a) == recordKey(b)
+}
+
+//DiffZone : compute the changes needed to go from the existing records to the wanted ones
+//In merge mode (replace = false) existing records missing from wanted are kept
+func DiffZone(existing []Record, wanted []Record, replace bool) ZoneChanges {
+	changes := ZoneChanges{Created: []Record{}, Updated: []Record{}, Deleted: []Record{}}
+
+	current := make(map[string]Record, len(existing))
+	for _, r := range existing {
+		if r.Type != 6 {
+			current[recordKey(r)] = r
+		}
+	}
+
+	seen := make(map[string]bool, len(wanted))
+	for _, r := range wanted {
+		key := recordKey(r)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		old, ok := current[key]
+		if !ok {
+			changes.Created = append(changes.Created, r)
+		} else if old.TTL != r.TTL {
+			old.TTL = r.TTL
+			changes.Updated = append(changes.Updated, old)
+		}
+	}
+
+	if replace {
+		for _, r := range existing {
+			if r.Type != 6 && !seen[recordKey(r)] {
+				changes.Deleted = append(changes.Deleted, r)
+			}
+		}
+	}
+
+	return changes
+}
+
+//ApplyChanges : apply the changes to the domain in a single transaction, bump the SOA once and log them in the domain history
+func (d *Domain) ApplyChanges(db *gorm.DB, user User, action string, changes ZoneChanges) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		history := []RecordChange{}
+
+		for i := range changes.Deleted {
+			before := changes.Deleted[i]
+			if err := changes.Deleted[i].DeleteRecord(tx); err != nil {
+				return err
+			}
+			history = append(history, RecordChange{Before: &before})
+		}
+		for i := range changes.Updated {
+			before := Record{ID: changes.Updated[i].ID}
+			if err := before.GetRecord(tx); err != nil {
+				return err
+			}
+			if err := changes.Updated[i].UpdateRecord(tx); err != nil {
+				return err
+			}
+			after := changes.Updated[i]
+			history = append(history, RecordChange{Before: &before, After: &after})
+		}
+		for i := range changes.Created {
+			if err := changes.Created[i].CreateRecord(tx); err != nil {
+				return err
+			}
+			after := changes.Created[i]
+			history = append(history, RecordChange{After: &after})
+		}
+
+		if err := d.UpdateSOA(tx, user); err != nil {
+			return err
+		}
+		return d.LogChanges(tx, user, action, history, nil, nil)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/outout14/sacrebleu-api/api/types"
 	"github.com/outout14/sacrebleu-dns/utils"
 	"golang.org/x/crypto/bcrypt"
 
@@ -21,6 +22,16 @@ type Server struct {
 	APIRouter *mux.Router
 	DB        *gorm.DB
 	Conf      *utils.Conf
+	Config    *Config //API only configuration
+	sessions  *sessionSigner
+	throttle  *loginThrottle
+	resets    *loginThrottle //Password reset requests (by IP and by email)
+	mailer    Mailer
+	oidc      *oidcClient
+	providers map[string]AuthProvider
+	keyring   *tsigKeyring
+	updater   *dnsUpdater
+	transfer  *dnsTransfer
 }
 
 //Response : Used to reply to http query
@@ -29,10 +40,32 @@ type Response struct {
 	Content  string `example:"Token invalid."`
 }
 
+//ValidationResponse : Used to reply to http query with an invalid object
+type ValidationResponse struct {
+	HTTPCode int    `example:"422"`
+	Content  string `example:"Invalid record."`
+	Errors   []types.ValidationError
+}
+
+//ConflictResponse : Used to reply to http query with a record conflicting with others
+type ConflictResponse struct {
+	HTTPCode  int    `example:"409"`
+	Content   string `example:"CNAME www.example.org. can't coexist with other records at the same name."`
+	RecordIDs []int  `example:"12,13"`
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, Response{HTTPCode: code, Content: message})
 }
 
+func respondWithValidationErrors(w http.ResponseWriter, message string, errs []types.ValidationError) {
+	respondWithJSON(w, http.StatusUnprocessableEntity, ValidationResponse{HTTPCode: http.StatusUnprocessableEntity, Content: message, Errors: errs})
+}
+
+func respondWithConflict(w http.ResponseWriter, conflict *types.ConflictError) {
+	respondWithJSON(w, http.StatusConflict, ConflictResponse{HTTPCode: http.StatusConflict, Content: conflict.Message, RecordIDs: conflict.RecordIDs})
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 
@@ -41,6 +74,12 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
+func respondWithText(w http.ResponseWriter, code int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(text))
+}
+
 func respondWithCode(w http.ResponseWriter, code int) {
 	w.WriteHeader(code)
 }
@@ -69,8 +108,13 @@ func calcCount(count int) int {
 
 //getID: get the GET id parameter
 func getID(r *http.Request, w http.ResponseWriter) (int, bool) {
+	return getVarID(r, w, "id")
+}
+
+//getVarID: get a GET id parameter by its name (eg : token_id)
+func getVarID(r *http.Request, w http.ResponseWriter, name string) (int, bool) {
 	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := strconv.Atoi(vars[name])
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid query ID")
 		return 0, true
@@ -84,10 +128,18 @@ func HashPassword(password string) (string, error) {
 	return string(bytes), err
 }
 
-//GenerateToken : generate user token based on the seed (eg : email)
-func GenerateToken(seed string) string {
-	b := make([]byte, 8)
+//GenerateToken : generate a random token secret
+func GenerateToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//generateUUID : generate a random (version 4) UUID
+func generateUUID() string {
+	b := make([]byte, 16)
 	rand.Read(b)
-	token := fmt.Sprintf("%s%x", seed, b)
-	return base64.StdEncoding.EncodeToString([]byte(token))
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
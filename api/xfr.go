@@ -0,0 +1,256 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/outout14/sacrebleu-api/api/types"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+//xfrMessageSize : size of the messages of a zone transfer (the records are split in several messages)
+const xfrMessageSize = 16384
+
+//dnsTransfer : zone transfers (AXFR / IXFR) listener, for the secondaries (UDP and TCP)
+type dnsTransfer struct {
+	db      *gorm.DB
+	addr    string
+	keyring *tsigKeyring
+}
+
+//newDNSTransfer : create the zone transfers listener (nil if it is disabled)
+func newDNSTransfer(conf XFR, db *gorm.DB, keyring *tsigKeyring) *dnsTransfer {
+	if conf.Listen == "" {
+		return nil
+	}
+	return &dnsTransfer{db: db, addr: conf.Listen, keyring: keyring}
+}
+
+//listen : start the UDP and TCP listeners
+func (t *dnsTransfer) listen() {
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: t.addr, Net: network, Handler: t, TsigProvider: t.keyring}
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				logrus.Fatalf("XFR : Can't listen on %s/%s : %s", server.Addr, server.Net, err)
+			}
+		}()
+	}
+	logrus.WithFields(logrus.Fields{"addr": t.addr}).Info("XFR : Started")
+}
+
+//ServeDNS : answer the SOA queries and the zone transfers of the secondaries
+func (t *dnsTransfer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	rrs, rcode := t.transfer(w, req)
+	if rcode != dns.RcodeSuccess {
+		m := new(dns.Msg)
+		m.SetRcode(req, rcode)
+		t.sign(w, req, m)
+		w.WriteMsg(m)
+		return
+	}
+
+	//Split in messages of xfrMessageSize bytes, the first one is signed with the whole TSIG, the next ones with the timers only
+	//The size is summed by record (m.Len packs the whole message each time)
+	m := t.reply(req)
+	empty := m.Len()
+	size := empty
+	for _, rr := range rrs {
+		rrSize := dns.Len(rr)
+		if size+rrSize > xfrMessageSize && len(m.Answer) > 0 {
+			t.sign(w, req, m)
+			if err := w.WriteMsg(m); err != nil {
+				return
+			}
+			w.TsigTimersOnly(true)
+			m = t.reply(req)
+			size = empty
+		}
+		m.Answer = append(m.Answer, rr)
+		size += rrSize
+	}
+	t.sign(w, req, m)
+	w.WriteMsg(m)
+}
+
+//reply : empty authoritative answer to the request
+func (t *dnsTransfer) reply(req *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	return m
+}
+
+//sign : sign the answer with the request key (but the NOTAUTH answers as the clients expect)
+func (t *dnsTransfer) sign(w dns.ResponseWriter, req *dns.Msg, m *dns.Msg) {
+	if tsig := req.IsTsig(); tsig != nil && w.TsigStatus() == nil && m.Rcode != dns.RcodeNotAuth {
+		m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsigFudge, time.Now().Unix())
+	}
+}
+
+//transfer : check the access to the zone and get the records of the answer (SOA, AXFR or IXFR)
+func (t *dnsTransfer) transfer(w dns.ResponseWriter, req *dns.Msg) ([]dns.RR, int) {
+	if req.Opcode != dns.OpcodeQuery {
+		return nil, dns.RcodeNotImplemented
+	}
+	if len(req.Question) != 1 {
+		return nil, dns.RcodeFormatError
+	}
+	q := req.Question[0]
+	if q.Qtype != dns.TypeSOA && q.Qtype != dns.TypeAXFR && q.Qtype != dns.TypeIXFR {
+		return nil, dns.RcodeRefused
+	}
+
+	zone := dns.Fqdn(strings.ToLower(q.Name))
+	source, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+	_, udp := w.RemoteAddr().(*net.UDPAddr)
+	logger := logrus.WithFields(logrus.Fields{"zone": zone, "ip": source, "type": dns.TypeToString[q.Qtype]})
+
+	d := types.Domain{Fqdn: zone}
+	err := d.GetDomainByFqdn(t.db)
+	if err == gorm.ErrRecordNotFound {
+		return nil, dns.RcodeNotAuth
+	}
+	if err != nil {
+		return nil, dns.RcodeServerFailure
+	}
+
+	if rcode := t.access(w, req, d, source); rcode != dns.RcodeSuccess {
+		logger.Info("XFR : Transfer refused")
+		return nil, rcode
+	}
+
+	records, err := d.GetDomainRecords(t.db, -1, -1)
+	if err != nil {
+		return nil, dns.RcodeServerFailure
+	}
+	var soa *dns.SOA
+	rrs := []dns.RR{}
+	for _, record := range records {
+		rr, err := record.RR()
+		if err != nil || rr == nil {
+			logger.WithField("record", record.ID).Errorf("XFR : Invalid record : %s", err)
+			continue
+		}
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	if soa == nil { //No SOA until the first change of the zone (or an invalid one) : the default one
+		record := d.DefaultSOA()
+		rr, err := record.RR()
+		if err != nil {
+			logger.Errorf("XFR : Invalid default SOA : %s", err)
+			return nil, dns.RcodeServerFailure
+		}
+		soa = rr.(*dns.SOA)
+	}
+
+	switch q.Qtype {
+	case dns.TypeSOA:
+		return []dns.RR{soa}, dns.RcodeSuccess
+	case dns.TypeAXFR:
+		if udp {
+			return nil, dns.RcodeRefused
+		}
+	case dns.TypeIXFR:
+		if len(req.Ns) != 1 || req.Ns[0].Header().Rrtype != dns.TypeSOA {
+			return nil, dns.RcodeFormatError
+		}
+		serial := req.Ns[0].(*dns.SOA).Serial
+
+		//Up to date, or the answer may not fit in UDP : the current SOA only (the secondary will use TCP)
+		if serial == soa.Serial || udp {
+			return []dns.RR{soa}, dns.RcodeSuccess
+		}
+		if incremental, err := t.incremental(d, soa, serial); err != nil {
+			return nil, dns.RcodeServerFailure
+		} else if incremental != nil {
+			logger.WithFields(logrus.Fields{"from": serial, "to": soa.Serial}).Info("XFR : Incremental transfer")
+			return incremental, dns.RcodeSuccess
+		}
+	}
+
+	//Whole zone (AXFR, or IXFR not covered by the history)
+	logger.WithField("serial", soa.Serial).Info("XFR : Full transfer")
+	return append(append([]dns.RR{soa}, rrs...), soa), dns.RcodeSuccess
+}
+
+//access : check if the source address is allowed by the domain, or the TSIG key of the request
+//The keys of the database need the transfer permission on the domain, the users of the keys of the configuration the viewer role
+func (t *dnsTransfer) access(w dns.ResponseWriter, req *dns.Msg, d types.Domain, source string) int {
+	tsig := req.IsTsig()
+	if tsig == nil {
+		if len(d.AllowTransfer) > 0 && d.AllowTransfer.Contains(source) {
+			return dns.RcodeSuccess
+		}
+		return dns.RcodeRefused
+	}
+
+	key, err := t.keyring.key(tsig.Hdr.Name)
+	if err != nil || w.TsigStatus() != nil {
+		return dns.RcodeNotAuth
+	}
+
+	if key.ID != 0 {
+		stored := types.TSIGKey{ID: key.ID}
+		allowed, err := stored.Allows(t.db, d.ID, types.TSIGTransfer)
+		if err != nil {
+			return dns.RcodeServerFailure
+		}
+		if !allowed {
+			return dns.RcodeRefused
+		}
+		return dns.RcodeSuccess
+	}
+
+	user := types.User{Username: key.Username}
+	if err := user.GetUserByUsername(t.db); err != nil {
+		return dns.RcodeRefused
+	}
+	role, err := user.DomainRole(t.db, d)
+	if err != nil {
+		return dns.RcodeServerFailure
+	}
+	if !types.RoleAllows(role, types.RoleViewer) {
+		return dns.RcodeRefused
+	}
+	return dns.RcodeSuccess
+}
+
+//incremental : IXFR answer from the domain history (RFC 1995 section 4), nil if the history doesn't cover the serial
+func (t *dnsTransfer) incremental(d types.Domain, soa *dns.SOA, serial uint32) ([]dns.RR, error) {
+	changes, ok, err := d.GetSerialChanges(t.db, serial)
+	if err != nil || !ok || len(changes) == 0 || changes[len(changes)-1].To != soa.Serial {
+		return nil, err
+	}
+
+	//The SOA of the older versions are the current one with their serial
+	versionSOA := func(serial uint32) dns.RR {
+		rr := dns.Copy(soa).(*dns.SOA)
+		rr.Serial = serial
+		return rr
+	}
+
+	rrs := []dns.RR{soa}
+	for _, change := range changes {
+		rrs = append(rrs, versionSOA(change.From))
+		for _, record := range change.Deleted {
+			if rr, err := record.RR(); err == nil && rr != nil {
+				rrs = append(rrs, rr)
+			}
+		}
+		rrs = append(rrs, versionSOA(change.To))
+		for _, record := range change.Added {
+			if rr, err := record.RR(); err == nil && rr != nil {
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return append(rrs, soa), nil
+}
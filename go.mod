@@ -4,14 +4,20 @@ go 1.15
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
+	github.com/coreos/go-oidc/v3 v3.0.0
+	github.com/go-ldap/ldap/v3 v3.2.4
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/context v1.1.1
 	github.com/gorilla/mux v1.8.0
+	github.com/miekg/dns v1.1.47
 	github.com/outout14/sacrebleu-dns v0.0.6-0.20210117221355-8e5bf6ebdbe2
 	github.com/rs/cors v1.7.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/swaggo/http-swagger v1.0.0
 	github.com/swaggo/swag v1.7.0
 	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899 // direct
+	golang.org/x/oauth2 v0.0.0-20210220000619-9bb904979d93
 	gopkg.in/ini.v1 v1.62.0
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.8
 )
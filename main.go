@@ -21,9 +21,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"strings"
 
+	"github.com/miekg/dns"
 	"github.com/outout14/sacrebleu-api/api"
 	"github.com/outout14/sacrebleu-api/api/types"
 	"github.com/outout14/sacrebleu-dns/utils"
@@ -41,12 +44,19 @@ func main() {
 	configPatch := flag.String("config", "config.ini", "the patch to the config file")  //Get the config patch from --config flag
 	sqlMigration := flag.Bool("sqlmigrate", false, "initialize / migrate the database") //Detect if migration asked
 	adminCreate := flag.Bool("createadmin", false, "create admin user in the database")
+	axfrImport := flag.String("axfrimport", "", "import the zone of an existing domain by AXFR (with --axfrprimary) and exit")
+	axfrPrimary := flag.String("axfrprimary", "", "the primary server to import the zone from (host[:port])")
+	axfrKey := flag.String("axfrkey", "", "the TSIG key of the zone transfer (name:algorithm:secret)")
+	axfrReplace := flag.Bool("axfrreplace", false, "delete the records missing from the primary zone")
 	flag.Parse()
 
 	//Load the INI configuration file
 	conf = new(utils.Conf)
 	err := ini.MapTo(conf, *configPatch)
 	utils.CheckErr(err)
+	apiConf := new(api.Config) //API only sections
+	err = ini.MapTo(apiConf, *configPatch)
+	utils.CheckErr(err)
 
 	//Swagger
 	docs.SwaggerInfo.Host = fmt.Sprintf("%s:%v", conf.App.IP, conf.App.Port)
@@ -69,19 +79,108 @@ func main() {
 		fmt.Print("Enter the password :")
 		fmt.Scanf("%s", &newAdmin.Password)
 		newAdmin.IsAdmin = true
-		newAdmin.Token = api.GenerateToken(newAdmin.Email)
 		newAdmin.Password, _ = api.HashPassword(newAdmin.Password)
+
+		//TOTP is optional (it can be enabled later with POST /api/user/self/totp)
+		var enableTOTP string
+		fmt.Print("Enable TOTP (y/N) :")
+		fmt.Scanln(&enableTOTP)
+		if strings.EqualFold(enableTOTP, "y") {
+			err := newAdmin.GenerateTOTPSecret()
+			utils.CheckErr(err)
+			logrus.Warningf("Add this TOTP secret to your authenticator app : %s", newAdmin.TOTPSecret)
+			logrus.Warningf("(or this URI as a QR code : %s)", newAdmin.TOTPURI())
+			for !newAdmin.TOTPEnabled {
+				var code string
+				fmt.Print("Enter the TOTP code :")
+				if _, err := fmt.Scanf("%s", &code); err != nil {
+					logrus.Errorf("Can't read the TOTP code : %s", err)
+					return
+				}
+				newAdmin.TOTPEnabled = newAdmin.CheckTOTP(db, code)
+				if !newAdmin.TOTPEnabled {
+					logrus.Error("Invalid TOTP code.")
+				}
+			}
+		}
+
 		err := newAdmin.CreateUser(db)
+		if err != nil {
+			logrus.Errorf("Can't create user : %s", err)
+			return
+		}
+		logrus.Warningf("User created.")
+
+		if newAdmin.TOTPEnabled {
+			codes, err := newAdmin.GenerateRecoveryCodes(db)
+			if err == nil {
+				logrus.Warningf("RECOVERY CODES (keep them safe) : %s", strings.Join(codes, " "))
+			} else {
+				logrus.Errorf("Can't create recovery codes : %s", err)
+			}
+		}
+
+		token := types.Token{UserID: newAdmin.ID, Name: "default", Secret: api.GenerateToken(), Scopes: newAdmin.DefaultScopes()}
+		err = token.CreateToken(db)
 		if err == nil {
-			logrus.Warningf("User created.")
-			logrus.Warningf("NEW USER TOKEN : %s\n", newAdmin.Token)
+			logrus.Warningf("NEW USER TOKEN : %s\n", token.Secret)
 		} else {
-			logrus.Errorf("Can't create user : %s", err)
+			logrus.Errorf("Can't create user token : %s", err)
+		}
+		return
+	}
+
+	if *axfrImport != "" {
+		if *axfrPrimary == "" {
+			logrus.Error("Missing primary server (--axfrprimary).")
+			return
+		}
+		d := types.Domain{Fqdn: dns.Fqdn(strings.ToLower(*axfrImport))}
+		if err := d.GetDomainByFqdn(db); err != nil {
+			logrus.Errorf("Can't get domain %s : %s", d.Fqdn, err)
+			return
+		}
+
+		var key *types.TSIGKey
+		if *axfrKey != "" {
+			parts := strings.SplitN(*axfrKey, ":", 3)
+			if len(parts) != 3 {
+				logrus.Error("Invalid TSIG key (name:algorithm:secret).")
+				return
+			}
+			key = &types.TSIGKey{Name: parts[0], Algorithm: parts[1], Secret: parts[2]}
+			if err := key.Validate(); err != nil {
+				logrus.Errorf("Invalid TSIG key : %s", err)
+				return
+			}
+		}
+
+		//The changes are made in the name of the domain owner
+		owner := types.User{ID: d.OwnerID}
+		if err := owner.GetUser(db); err != nil {
+			logrus.Errorf("Can't get the owner of domain %s : %s", d.Fqdn, err)
+			return
+		}
+
+		transfer, err := d.ImportTransfer(db, owner, *axfrPrimary, key, *axfrReplace, false, nil)
+		var recordErr *types.RecordError
+		var conflict *types.ConflictError
+		switch {
+		case errors.As(err, &recordErr):
+			logrus.Errorf("%s : %v", recordErr, recordErr.Errors)
+			return
+		case errors.As(err, &conflict):
+			logrus.Errorf("The zone conflicts with the records %v : %s", conflict.RecordIDs, conflict)
+			return
+		case err != nil:
+			logrus.Errorf("Can't import the zone : %s", err)
+			return
 		}
+		logrus.WithFields(logrus.Fields{"serial": transfer.Serial, "created": len(transfer.Changes.Created), "updated": len(transfer.Changes.Updated), "deleted": len(transfer.Changes.Deleted), "skipped": transfer.Skipped}).Warningf("Zone %s imported from %s.", d.Fqdn, transfer.Primary)
 		return
 	}
 
-	a := api.Server{DB: db}
+	a := api.Server{DB: db, Config: apiConf}
 	a.Initialize(conf)
 
 	a.Run(conf)